@@ -0,0 +1,169 @@
+// Copyright 2024
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gotasty
+
+import (
+	"fmt"
+	"math"
+)
+
+// EstimateOptionMargin estimates the margin requirement for a naked short
+// option position using the standard CBOE formula for equity options:
+//
+//	calls: max(20% of underlying - OTM amount, 10% of underlying)
+//	puts:  max(20% of underlying - OTM amount, 10% of strike)
+//
+// multiplied by 100 shares per contract. Right must be either 'C' (call) or
+// 'P' (put); any other value returns 0. Premium received is not included.
+//
+// This is only an estimate; the actual requirement charged by tastytrade may
+// differ. Submit a dry-run order for the authoritative value.
+func EstimateOptionMargin(underlyingPrice, strike float64, right rune, contracts int64) float64 {
+	var otm, minimum float64
+
+	switch right {
+	case 'C', 'c':
+		otm = math.Max(strike-underlyingPrice, 0)
+		minimum = 0.10 * underlyingPrice
+	case 'P', 'p':
+		otm = math.Max(underlyingPrice-strike, 0)
+		minimum = 0.10 * strike
+	default:
+		return 0
+	}
+
+	perShare := math.Max(0.20*underlyingPrice-otm, minimum)
+	return perShare * 100 * math.Abs(float64(contracts))
+}
+
+// AssignmentRisk returns true if pos is a short equity option that is in the
+// money at underlyingPrice and is therefore likely to be assigned if held
+// through expiration. Long positions, non-option positions, and positions
+// whose symbol cannot be parsed are never at risk.
+func AssignmentRisk(pos *Position, underlyingPrice float64) bool {
+	if pos.QuantityDirection != "Short" || pos.Quantity == 0 ||
+		InstrumentTypeFromString(pos.InstrumentType) != EquityOption {
+		return false
+	}
+
+	option, err := ParseOptionSymbol(pos.Symbol)
+	if err != nil {
+		return false
+	}
+
+	switch option.OptionType {
+	case "C":
+		return underlyingPrice > option.StrikePrice
+	case "P":
+		return underlyingPrice < option.StrikePrice
+	default:
+		return false
+	}
+}
+
+// RiskProfile returns the maximum profit and maximum loss at expiration of
+// an option order, such as a vertical spread or iron condor, in dollars.
+// The payoff of each leg is computed from the strike in its OCC symbol and
+// the order's net Price and PriceEffect, then scaled by multiplier (e.g. 100
+// for standard equity options). maxLoss is returned as a positive amount.
+//
+// An error wrapping ErrUndefinedRisk is returned if the loss is unbounded,
+// e.g. for a naked short call. If the profit is unbounded maxProfit is +Inf.
+func (order *Order) RiskProfile(multiplier float64) (maxProfit, maxLoss float64, err error) {
+	if len(order.Legs) == 0 {
+		return 0, 0, fmt.Errorf("%w: order has no legs", ErrUndefinedRisk)
+	}
+
+	type optionLeg struct {
+		option   *OptionSymbol
+		quantity float64 // positive for long legs and negative for short legs
+	}
+
+	legs := make([]optionLeg, len(order.Legs))
+	prices := []float64{0}
+	var lots int64
+	var slope float64 // change in payoff per $1 increase in the underlying above the highest strike
+
+	for idx, leg := range order.Legs {
+		option, err := ParseOptionSymbol(leg.Symbol)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		quantity := float64(leg.Quantity)
+		switch leg.Action {
+		case Sell, SellToOpen, SellToClose:
+			quantity = -quantity
+		}
+
+		legs[idx] = optionLeg{option: option, quantity: quantity}
+		prices = append(prices, option.StrikePrice)
+		lots = gcd(lots, leg.Quantity)
+
+		if option.OptionType == "C" {
+			slope += quantity
+		}
+	}
+
+	if lots == 0 {
+		return 0, 0, fmt.Errorf("%w: order legs have no quantity", ErrUndefinedRisk)
+	}
+
+	if slope < 0 {
+		return 0, 0, fmt.Errorf("%w: loss is unbounded as the underlying rises", ErrUndefinedRisk)
+	}
+
+	// the order price is quoted per unit of the spread
+	premium := order.Price * float64(lots)
+	if order.PriceEffect == Debit {
+		premium = -premium
+	}
+
+	payoff := func(underlyingPrice float64) float64 {
+		value := premium
+		for _, leg := range legs {
+			switch leg.option.OptionType {
+			case "C":
+				value += leg.quantity * math.Max(underlyingPrice-leg.option.StrikePrice, 0)
+			case "P":
+				value += leg.quantity * math.Max(leg.option.StrikePrice-underlyingPrice, 0)
+			}
+		}
+		return value
+	}
+
+	// the payoff is piecewise linear so its extremes are at a strike or at 0
+	maxProfit, minPayoff := math.Inf(-1), math.Inf(1)
+	for _, price := range prices {
+		value := payoff(price)
+		maxProfit = math.Max(maxProfit, value)
+		minPayoff = math.Min(minPayoff, value)
+	}
+
+	if slope > 0 {
+		maxProfit = math.Inf(1)
+	}
+
+	return maxProfit * multiplier, math.Max(-minPayoff, 0) * multiplier, nil
+}
+
+// gcd returns the greatest common divisor of two non-negative integers
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
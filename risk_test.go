@@ -0,0 +1,170 @@
+// Copyright 2024
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gotasty
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestEstimateOptionMargin(t *testing.T) {
+	testCases := []struct {
+		name      string
+		strike    float64
+		right     rune
+		contracts int64
+		want      float64
+	}{
+		{"ITM call", 90, 'C', 1, 2000},
+		{"OTM call", 110, 'C', 1, 1000},
+		{"far OTM call uses 10% of underlying floor", 150, 'c', 1, 1000},
+		{"ITM put", 110, 'P', 1, 2000},
+		{"OTM put", 95, 'P', 1, 1500},
+		{"far OTM put uses 10% of strike floor", 80, 'p', 1, 800},
+		{"negative contracts", 90, 'C', -2, 4000},
+		{"unknown right", 90, 'X', 1, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := EstimateOptionMargin(100, tc.strike, tc.right, tc.contracts); !approxEqual(got, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAssignmentRisk(t *testing.T) {
+	testCases := []struct {
+		name     string
+		position Position
+		want     bool
+	}{
+		{"ITM short call", Position{Symbol: "SPY   240119C00450000", InstrumentType: "Equity Option", Quantity: 1, QuantityDirection: "Short"}, true},
+		{"OTM short call", Position{Symbol: "SPY   240119C00470000", InstrumentType: "Equity Option", Quantity: 1, QuantityDirection: "Short"}, false},
+		{"ITM short put", Position{Symbol: "SPY   240119P00470000", InstrumentType: "Equity Option", Quantity: 1, QuantityDirection: "Short"}, true},
+		{"OTM short put", Position{Symbol: "SPY   240119P00450000", InstrumentType: "Equity Option", Quantity: 1, QuantityDirection: "Short"}, false},
+		{"ITM long call", Position{Symbol: "SPY   240119C00450000", InstrumentType: "Equity Option", Quantity: 1, QuantityDirection: "Long"}, false},
+		{"short equity", Position{Symbol: "SPY", InstrumentType: "Equity", Quantity: 100, QuantityDirection: "Short"}, false},
+		{"unparsable symbol", Position{Symbol: "SPY CALL", InstrumentType: "Equity Option", Quantity: 1, QuantityDirection: "Short"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := AssignmentRisk(&tc.position, 460); got != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestOrderRiskProfile(t *testing.T) {
+	testCases := []struct {
+		name       string
+		order      Order
+		wantProfit float64
+		wantLoss   float64
+		wantErr    error
+	}{
+		{
+			name: "vertical",
+			order: Order{Price: 1.25, PriceEffect: Credit, Legs: []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00450000", Quantity: 2, Action: SellToOpen},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00445000", Quantity: 2, Action: BuyToOpen},
+			}},
+			wantProfit: 250,
+			wantLoss:   750,
+		},
+		{
+			name: "iron condor",
+			order: Order{Price: 2, PriceEffect: Credit, Legs: []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00435000", Quantity: 1, Action: BuyToOpen},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00440000", Quantity: 1, Action: SellToOpen},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00460000", Quantity: 1, Action: SellToOpen},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00465000", Quantity: 1, Action: BuyToOpen},
+			}},
+			wantProfit: 200,
+			wantLoss:   300,
+		},
+		{
+			name: "butterfly",
+			order: Order{Price: 1.20, PriceEffect: Debit, Legs: []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00445000", Quantity: 1, Action: BuyToOpen},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00450000", Quantity: 2, Action: SellToOpen},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00455000", Quantity: 1, Action: BuyToOpen},
+			}},
+			wantProfit: 380,
+			wantLoss:   120,
+		},
+		{
+			name: "long call",
+			order: Order{Price: 3, PriceEffect: Debit, Legs: []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00450000", Quantity: 1, Action: BuyToOpen},
+			}},
+			wantProfit: math.Inf(1),
+			wantLoss:   300,
+		},
+		{
+			name: "naked short call",
+			order: Order{Price: 3, PriceEffect: Credit, Legs: []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00450000", Quantity: 1, Action: SellToOpen},
+			}},
+			wantErr: ErrUndefinedRisk,
+		},
+		{
+			name: "zero quantity",
+			order: Order{Price: 1, PriceEffect: Credit, Legs: []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00450000", Action: SellToOpen},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00445000", Action: BuyToOpen},
+			}},
+			wantErr: ErrUndefinedRisk,
+		},
+		{
+			name:    "no legs",
+			order:   Order{Price: 1, PriceEffect: Credit},
+			wantErr: ErrUndefinedRisk,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			maxProfit, maxLoss, err := tc.order.RiskProfile(100)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !approxEqual(maxProfit, tc.wantProfit) || !approxEqual(maxLoss, tc.wantLoss) {
+				t.Errorf("expected profit %v and loss %v, got %v and %v", tc.wantProfit, tc.wantLoss, maxProfit, maxLoss)
+			}
+		})
+	}
+}
+
+// approxEqual reports whether a and b are equal within floating point error
+func approxEqual(a, b float64) bool {
+	if math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return a == b
+	}
+	return math.Abs(a-b) < 1e-9
+}
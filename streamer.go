@@ -0,0 +1,546 @@
+// Copyright 2024
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gotasty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	defaultHeartbeatInterval  = 30 * time.Second
+	defaultReconnectDelay     = 5 * time.Second
+	defaultReconnectJitter    = 0.2
+	defaultStreamerBufferSize = 100
+)
+
+// StreamerDialer opens the websocket connection to the account streamer at
+// url. Supply a custom dialer, e.g. one that calls websocket.DialConfig with
+// a proxy or TLS configuration, in AccountStreamerOpts.
+type StreamerDialer func(url, origin string) (*websocket.Conn, error)
+
+// AccountStreamerOpts provide additional settings when creating an account streamer
+type AccountStreamerOpts struct {
+	// how often to send a heartbeat; the streamer closes connections that
+	// have been silent for 60 seconds. Defaults to 30 seconds.
+	HeartbeatInterval time.Duration
+
+	// how long to wait before reconnecting after the connection is lost.
+	// Defaults to 5 seconds.
+	ReconnectDelay time.Duration
+
+	// fraction of ReconnectDelay to randomly add or subtract from each
+	// reconnect delay so that clients disconnected together do not all
+	// reconnect at once. Defaults to 0.2; set a negative value to disable.
+	ReconnectJitter float64
+
+	// number of notifications buffered on each channel. Notifications that
+	// arrive while a channel's buffer is full are dropped and counted by
+	// Dropped. Defaults to 100.
+	BufferSize int
+
+	// opens the websocket connection, including when reconnecting. Defaults
+	// to websocket.Dial.
+	Dialer StreamerDialer
+
+	// accounts to subscribe to when the streamer connects. More accounts
+	// can be added later with SubscribeAccounts.
+	Accounts []string
+}
+
+// Subscription is an account the streamer is subscribed to. Subscriptions can
+// be persisted with ExportSubscriptions and restored on a new streamer with
+// ImportSubscriptions.
+type Subscription struct {
+	AccountNumber string `json:"account-number"`
+}
+
+// AccountStreamer delivers real-time notifications for the subscribed
+// accounts: order updates, balance changes, and position changes. The
+// streamer automatically reconnects, and re-subscribes to its accounts,
+// if the connection is lost. If the session can no longer be authenticated
+// the streamer stops and Err reports why. Each channel is buffered;
+// notifications are dropped, and counted by Dropped, if a channel is not
+// read quickly enough.
+type AccountStreamer struct {
+	session *Session
+	opts    AccountStreamerOpts
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	conn      *websocket.Conn
+	accounts  []string
+	requestID int64
+	err       error
+	dropped   atomic.Int64
+	watchers  map[string][]chan *OrderStatus
+
+	orders    chan *OrderStatus
+	balances  chan *Balance
+	positions chan *Position
+	errors    chan error
+	done      chan struct{}
+}
+
+// NewAccountStreamer connects to the account streamer. The streamer runs
+// until ctx is cancelled or Close is called. Call SubscribeAccounts to start
+// receiving notifications.
+func (session *Session) NewAccountStreamer(ctx context.Context, opts ...AccountStreamerOpts) (*AccountStreamer, error) {
+	var opt AccountStreamerOpts
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	if opt.HeartbeatInterval <= 0 {
+		opt.HeartbeatInterval = defaultHeartbeatInterval
+	}
+
+	if opt.ReconnectDelay <= 0 {
+		opt.ReconnectDelay = defaultReconnectDelay
+	}
+
+	switch {
+	case opt.ReconnectJitter == 0:
+		opt.ReconnectJitter = defaultReconnectJitter
+	case opt.ReconnectJitter < 0:
+		opt.ReconnectJitter = 0
+	case opt.ReconnectJitter > 1:
+		opt.ReconnectJitter = 1
+	}
+
+	if opt.BufferSize <= 0 {
+		opt.BufferSize = defaultStreamerBufferSize
+	}
+
+	if opt.Dialer == nil {
+		opt.Dialer = dialStreamer
+	}
+
+	streamer := &AccountStreamer{
+		session:   session,
+		opts:      opt,
+		orders:    make(chan *OrderStatus, opt.BufferSize),
+		balances:  make(chan *Balance, opt.BufferSize),
+		positions: make(chan *Position, opt.BufferSize),
+		errors:    make(chan error, opt.BufferSize),
+		done:      make(chan struct{}),
+	}
+
+	for _, accountNumber := range opt.Accounts {
+		if !slices.Contains(streamer.accounts, accountNumber) {
+			streamer.accounts = append(streamer.accounts, accountNumber)
+		}
+	}
+
+	streamer.ctx, streamer.cancel = context.WithCancel(ctx)
+
+	conn, err := streamer.connect()
+	if err != nil {
+		streamer.cancel()
+		return nil, err
+	}
+
+	streamer.wg.Add(2)
+	go streamer.heartbeat()
+	go func() {
+		defer streamer.wg.Done()
+		<-streamer.ctx.Done()
+		streamer.mu.Lock()
+		defer streamer.mu.Unlock()
+		streamer.conn.Close()
+	}()
+
+	go streamer.run(conn)
+
+	return streamer, nil
+}
+
+// Orders returns a channel of order status updates. Updates for all
+// subscribed accounts share the channel; use AccountNumber to tell them apart.
+func (streamer *AccountStreamer) Orders() <-chan *OrderStatus {
+	return streamer.orders
+}
+
+// WatchOrder returns a channel of status updates for the order with id, e.g.
+// the ID of the OrderStatus returned by SubmitOrder. Updates are also sent on
+// the Orders channel. The channel is closed once the order reaches a terminal
+// status or the streamer stops.
+func (streamer *AccountStreamer) WatchOrder(id string) <-chan *OrderStatus {
+	ch := make(chan *OrderStatus, streamer.opts.BufferSize)
+
+	streamer.mu.Lock()
+	defer streamer.mu.Unlock()
+
+	if streamer.ctx.Err() != nil {
+		close(ch)
+		return ch
+	}
+
+	if streamer.watchers == nil {
+		streamer.watchers = make(map[string][]chan *OrderStatus)
+	}
+	streamer.watchers[id] = append(streamer.watchers[id], ch)
+
+	return ch
+}
+
+// Balances returns a channel of account balance updates for all subscribed
+// accounts, tagged with their AccountNumber
+func (streamer *AccountStreamer) Balances() <-chan *Balance {
+	return streamer.balances
+}
+
+// Positions returns a channel of position updates for all subscribed
+// accounts, tagged with their AccountNumber
+func (streamer *AccountStreamer) Positions() <-chan *Position {
+	return streamer.positions
+}
+
+// Errors returns a channel of errors encountered by the streamer, such as
+// lost connections and rejected subscriptions. Errors are dropped if the
+// channel is not read.
+func (streamer *AccountStreamer) Errors() <-chan error {
+	return streamer.errors
+}
+
+// Err returns the error that stopped the streamer or nil if the streamer is
+// running or was closed normally. The streamer stops with ErrSessionExpired
+// when the session token cannot be refreshed.
+func (streamer *AccountStreamer) Err() error {
+	streamer.mu.Lock()
+	defer streamer.mu.Unlock()
+	return streamer.err
+}
+
+// Dropped returns the number of order, balance, and position notifications
+// dropped because their channel's buffer, including channels returned by
+// WatchOrder, was full
+func (streamer *AccountStreamer) Dropped() int64 {
+	return streamer.dropped.Load()
+}
+
+// SubscribeAccounts starts streaming notifications for accountNumbers in
+// addition to any accounts already subscribed. Nothing is sent to the
+// streamer if every account is already subscribed.
+func (streamer *AccountStreamer) SubscribeAccounts(accountNumbers []string) error {
+	streamer.mu.Lock()
+	added := false
+	for _, accountNumber := range accountNumbers {
+		if !slices.Contains(streamer.accounts, accountNumber) {
+			streamer.accounts = append(streamer.accounts, accountNumber)
+			added = true
+		}
+	}
+	accounts := slices.Clone(streamer.accounts)
+	streamer.mu.Unlock()
+
+	if !added {
+		return nil
+	}
+
+	return streamer.send("connect", accounts)
+}
+
+// ExportSubscriptions returns the streamer's current subscriptions
+func (streamer *AccountStreamer) ExportSubscriptions() []Subscription {
+	streamer.mu.Lock()
+	defer streamer.mu.Unlock()
+
+	subscriptions := make([]Subscription, len(streamer.accounts))
+	for idx, accountNumber := range streamer.accounts {
+		subscriptions[idx] = Subscription{AccountNumber: accountNumber}
+	}
+
+	return subscriptions
+}
+
+// ImportSubscriptions subscribes to each of subscriptions, e.g. to resume the
+// subscriptions exported from a previous streamer
+func (streamer *AccountStreamer) ImportSubscriptions(subscriptions []Subscription) error {
+	accountNumbers := make([]string, len(subscriptions))
+	for idx, subscription := range subscriptions {
+		accountNumbers[idx] = subscription.AccountNumber
+	}
+
+	return streamer.SubscribeAccounts(accountNumbers)
+}
+
+// Close disconnects the streamer and closes its channels
+func (streamer *AccountStreamer) Close() error {
+	streamer.cancel()
+	<-streamer.done
+	return nil
+}
+
+// connect dials the account streamer and re-subscribes to the streamer's
+// accounts
+func (streamer *AccountStreamer) connect() (*websocket.Conn, error) {
+	conn, err := streamer.opts.Dialer(streamer.session.AccountStreamerURL, streamer.session.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	streamer.mu.Lock()
+	streamer.conn = conn
+	accounts := slices.Clone(streamer.accounts)
+	streamer.mu.Unlock()
+
+	if len(accounts) > 0 {
+		if err := streamer.send("connect", accounts); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// dialStreamer is the default StreamerDialer
+func dialStreamer(url, origin string) (*websocket.Conn, error) {
+	return websocket.Dial(url, "", origin)
+}
+
+// run reads messages from conn and reconnects when the connection is lost
+// until the streamer is closed
+func (streamer *AccountStreamer) run(conn *websocket.Conn) {
+	defer func() {
+		streamer.wg.Wait()
+
+		streamer.mu.Lock()
+		for _, watchers := range streamer.watchers {
+			for _, ch := range watchers {
+				close(ch)
+			}
+		}
+		streamer.watchers = nil
+		streamer.mu.Unlock()
+
+		close(streamer.orders)
+		close(streamer.balances)
+		close(streamer.positions)
+		close(streamer.errors)
+		close(streamer.done)
+	}()
+
+	for {
+		err := streamer.read(conn)
+		if streamer.ctx.Err() != nil {
+			return
+		}
+
+		streamer.reportError(fmt.Errorf("%w: %w", ErrStreamerDisconnected, err))
+
+		for {
+			select {
+			case <-streamer.ctx.Done():
+				return
+			case <-time.After(streamer.reconnectDelay()):
+			}
+
+			if conn, err = streamer.connect(); err == nil {
+				break
+			}
+
+			if isAuthError(err) {
+				streamer.fail(err)
+				return
+			}
+
+			streamer.reportError(fmt.Errorf("%w: %w", ErrStreamerDisconnected, err))
+		}
+
+		// the connection may have been replaced after the context was
+		// cancelled; make sure it is closed
+		if streamer.ctx.Err() != nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// reconnectDelay returns ReconnectDelay randomly adjusted by up to
+// ReconnectJitter of its length
+func (streamer *AccountStreamer) reconnectDelay() time.Duration {
+	jitter := streamer.opts.ReconnectJitter * (2*rand.Float64() - 1)
+	return time.Duration(float64(streamer.opts.ReconnectDelay) * (1 + jitter))
+}
+
+// read handles messages from conn until the connection fails
+func (streamer *AccountStreamer) read(conn *websocket.Conn) error {
+	for {
+		var raw string
+		if err := websocket.Message.Receive(conn, &raw); err != nil {
+			return err
+		}
+
+		streamer.handle(raw)
+	}
+}
+
+// handle dispatches a single message received from the streamer
+func (streamer *AccountStreamer) handle(raw string) {
+	msg := gjson.Parse(raw)
+
+	// responses to actions sent by the streamer
+	if status := msg.Get("status"); status.Exists() {
+		if status.String() != "ok" {
+			streamer.reportError(fmt.Errorf("%w: %s: %s", ErrStreamerRequestFailed, msg.Get("action").String(), msg.Get("message").String()))
+		}
+		return
+	}
+
+	data := msg.Get("data")
+	switch msg.Get("type").String() {
+	case "Order":
+		orderStatus := parseOrderStatus(data)
+		streamer.notifyWatchers(orderStatus)
+		deliver(streamer, streamer.orders, orderStatus)
+	case "AccountBalance":
+		deliver(streamer, streamer.balances, parseBalance(data))
+	case "CurrentPosition":
+		deliver(streamer, streamer.positions, parsePosition(data))
+	default:
+		log.Debug().Str("type", msg.Get("type").String()).Msg("ignoring account streamer notification")
+	}
+}
+
+// notifyWatchers sends orderStatus to the channels returned by WatchOrder for
+// the order and closes them if the order is terminal
+func (streamer *AccountStreamer) notifyWatchers(orderStatus *OrderStatus) {
+	streamer.mu.Lock()
+	defer streamer.mu.Unlock()
+
+	watchers := streamer.watchers[orderStatus.ID]
+	for _, ch := range watchers {
+		deliver(streamer, ch, orderStatus)
+	}
+
+	if orderStatus.IsTerminal() {
+		for _, ch := range watchers {
+			close(ch)
+		}
+		delete(streamer.watchers, orderStatus.ID)
+	}
+}
+
+// heartbeat periodically sends a heartbeat to keep the connection alive
+func (streamer *AccountStreamer) heartbeat() {
+	defer streamer.wg.Done()
+
+	ticker := time.NewTicker(streamer.opts.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-streamer.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := streamer.send("heartbeat", nil); err != nil {
+				if isAuthError(err) {
+					streamer.fail(err)
+					return
+				}
+				streamer.reportError(err)
+			}
+		}
+	}
+}
+
+// send writes an action to the current connection
+func (streamer *AccountStreamer) send(action string, value any) error {
+	token, err := streamer.session.AuthorizationHeader()
+	if err != nil {
+		return err
+	}
+
+	streamer.mu.Lock()
+	defer streamer.mu.Unlock()
+
+	streamer.requestID++
+	msg, err := json.Marshal(struct {
+		Action    string `json:"action"`
+		Value     any    `json:"value,omitempty"`
+		AuthToken string `json:"auth-token"`
+		RequestID int64  `json:"request-id"`
+	}{
+		Action:    action,
+		Value:     value,
+		AuthToken: token,
+		RequestID: streamer.requestID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return websocket.Message.Send(streamer.conn, string(msg))
+}
+
+// reportError sends err to the errors channel without blocking
+func (streamer *AccountStreamer) reportError(err error) {
+	select {
+	case streamer.errors <- err:
+	default:
+		log.Warn().Err(err).Msg("account streamer error dropped")
+	}
+}
+
+// fail stops the streamer because the session can no longer be
+// authenticated; retrying cannot succeed so err is reported and recorded for
+// Err as an ErrSessionExpired
+func (streamer *AccountStreamer) fail(err error) {
+	if !errors.Is(err, ErrSessionExpired) {
+		err = fmt.Errorf("%w: %w", ErrSessionExpired, err)
+	}
+
+	streamer.mu.Lock()
+	if streamer.err == nil {
+		streamer.err = err
+	}
+	streamer.mu.Unlock()
+
+	streamer.reportError(err)
+	streamer.cancel()
+}
+
+// isAuthError returns true if err means the session can no longer be
+// authenticated
+func isAuthError(err error) bool {
+	return errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrRememberTokenExpired)
+}
+
+// deliver sends value to ch without blocking; value is dropped if ch is full
+func deliver[T any](streamer *AccountStreamer, ch chan T, value T) {
+	select {
+	case ch <- value:
+	default:
+		streamer.dropped.Add(1)
+		log.Debug().Int64("Dropped", streamer.dropped.Load()).Msg("account streamer notification dropped")
+	}
+}
@@ -0,0 +1,605 @@
+// Copyright 2024
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gotasty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// newStreamerTestSession returns a session whose account streamer
+// connections are served by handler
+func newStreamerTestSession(t *testing.T, handler func(*websocket.Conn)) *Session {
+	t.Helper()
+	return newTestSession(t, websocket.Handler(handler).ServeHTTP)
+}
+
+// drainErrors collects errors from the streamer until it stops
+func drainErrors(t *testing.T, streamer *AccountStreamer) []error {
+	t.Helper()
+
+	var errs []error
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err, ok := <-streamer.Errors():
+			if !ok {
+				return errs
+			}
+			errs = append(errs, err)
+		case <-timeout:
+			t.Fatal("streamer did not stop")
+		}
+	}
+}
+
+func TestAccountStreamerStopsWhenHeartbeatCannotAuthenticate(t *testing.T) {
+	session := newStreamerTestSession(t, func(conn *websocket.Conn) {
+		var raw string
+		for websocket.Message.Receive(conn, &raw) == nil {
+		}
+	})
+	session.ExpiresOn = time.Now().Add(-time.Hour)
+
+	streamer, err := session.NewAccountStreamer(context.Background(), AccountStreamerOpts{HeartbeatInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewAccountStreamer: %v", err)
+	}
+
+	errs := drainErrors(t, streamer)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrSessionExpired) {
+		t.Errorf("expected a single ErrSessionExpired, got %v", errs)
+	}
+
+	if !errors.Is(streamer.Err(), ErrSessionExpired) {
+		t.Errorf("expected Err to be ErrSessionExpired, got %v", streamer.Err())
+	}
+}
+
+func TestAccountStreamerStopsWhenReconnectCannotAuthenticate(t *testing.T) {
+	connections := make(chan struct{}, 10)
+	session := newStreamerTestSession(t, func(conn *websocket.Conn) {
+		connections <- struct{}{}
+
+		// drop the connection once the streamer subscribes
+		var raw string
+		websocket.Message.Receive(conn, &raw)
+	})
+
+	// the session token is usable long enough to subscribe, but expires
+	// before the streamer reconnects
+	session.ExpiresOn = time.Now().Add(5*time.Minute + 300*time.Millisecond)
+
+	streamer, err := session.NewAccountStreamer(context.Background(), AccountStreamerOpts{
+		HeartbeatInterval: time.Hour,
+		ReconnectDelay:    500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewAccountStreamer: %v", err)
+	}
+
+	if err := streamer.SubscribeAccounts([]string{"5WT0001"}); err != nil {
+		t.Fatalf("SubscribeAccounts: %v", err)
+	}
+
+	errs := drainErrors(t, streamer)
+	if len(errs) != 2 || !errors.Is(errs[0], ErrStreamerDisconnected) || !errors.Is(errs[1], ErrSessionExpired) {
+		t.Errorf("expected a disconnect followed by ErrSessionExpired, got %v", errs)
+	}
+
+	if !errors.Is(streamer.Err(), ErrSessionExpired) {
+		t.Errorf("expected Err to be ErrSessionExpired, got %v", streamer.Err())
+	}
+
+	if len(connections) != 2 {
+		t.Errorf("expected 2 connections, got %d", len(connections))
+	}
+}
+
+func TestAccountStreamerTagsEventsWithAccount(t *testing.T) {
+	subscribed := make(chan []string, 1)
+	session := newStreamerTestSession(t, func(conn *websocket.Conn) {
+		var msg struct {
+			Action string   `json:"action"`
+			Value  []string `json:"value"`
+		}
+		if err := websocket.JSON.Receive(conn, &msg); err != nil || msg.Action != "connect" {
+			t.Errorf("expected connect action, got %+v (%v)", msg, err)
+			return
+		}
+		subscribed <- msg.Value
+
+		for _, event := range []string{
+			`{"type":"Order","data":{"id":1,"account-number":"5WT0001","status":"Live"}}`,
+			`{"type":"Order","data":{"id":2,"account-number":"5WT0002","status":"Filled"}}`,
+			`{"type":"AccountBalance","data":{"account-number":"5WT0002","cash-balance":"100.0"}}`,
+			`{"type":"AccountBalance","data":{"account-number":"5WT0001","cash-balance":"200.0"}}`,
+		} {
+			websocket.Message.Send(conn, event)
+		}
+
+		var raw string
+		for websocket.Message.Receive(conn, &raw) == nil {
+		}
+	})
+
+	streamer, err := session.NewAccountStreamer(context.Background(), AccountStreamerOpts{
+		Accounts: []string{"5WT0001", "5WT0002", "5WT0001"},
+	})
+	if err != nil {
+		t.Fatalf("NewAccountStreamer: %v", err)
+	}
+	defer streamer.Close()
+
+	if accounts := <-subscribed; !reflect.DeepEqual(accounts, []string{"5WT0001", "5WT0002"}) {
+		t.Errorf("expected to subscribe to both accounts once, got %v", accounts)
+	}
+
+	orders := map[string]string{}
+	for idx := 0; idx < 2; idx++ {
+		order := <-streamer.Orders()
+		orders[order.ID] = order.AccountNumber
+	}
+	if !reflect.DeepEqual(orders, map[string]string{"1": "5WT0001", "2": "5WT0002"}) {
+		t.Errorf("unexpected order tagging: %v", orders)
+	}
+
+	balances := map[string]float64{}
+	for idx := 0; idx < 2; idx++ {
+		balance := <-streamer.Balances()
+		balances[balance.AccountNumber] = balance.CashBalance
+	}
+	if !reflect.DeepEqual(balances, map[string]float64{"5WT0001": 200, "5WT0002": 100}) {
+		t.Errorf("unexpected balance tagging: %v", balances)
+	}
+}
+
+func TestAccountStreamerSubscribeAccountsSkipsDuplicates(t *testing.T) {
+	subscribed := make(chan []string, 10)
+	session := newStreamerTestSession(t, func(conn *websocket.Conn) {
+		for {
+			var msg struct {
+				Action string   `json:"action"`
+				Value  []string `json:"value"`
+			}
+			if err := websocket.JSON.Receive(conn, &msg); err != nil {
+				return
+			}
+			if msg.Action == "connect" {
+				subscribed <- msg.Value
+			}
+		}
+	})
+
+	streamer, err := session.NewAccountStreamer(context.Background())
+	if err != nil {
+		t.Fatalf("NewAccountStreamer: %v", err)
+	}
+	defer streamer.Close()
+
+	// messages arrive in order, so a connect sent for the duplicate would be
+	// received before the one for 5WT0002
+	for _, accountNumber := range []string{"5WT0001", "5WT0001", "5WT0002"} {
+		if err := streamer.SubscribeAccounts([]string{accountNumber}); err != nil {
+			t.Fatalf("SubscribeAccounts: %v", err)
+		}
+	}
+
+	want := [][]string{{"5WT0001"}, {"5WT0001", "5WT0002"}}
+	for _, accounts := range want {
+		select {
+		case got := <-subscribed:
+			if !reflect.DeepEqual(got, accounts) {
+				t.Errorf("expected connect for %v, got %v", accounts, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("streamer did not subscribe")
+		}
+	}
+}
+
+func TestAccountStreamerDropsWhenReaderIsSlow(t *testing.T) {
+	session := newStreamerTestSession(t, func(conn *websocket.Conn) {
+		var raw string
+		if websocket.Message.Receive(conn, &raw) != nil {
+			return
+		}
+
+		for idx := 1; idx <= 5; idx++ {
+			websocket.Message.Send(conn, fmt.Sprintf(`{"type":"Order","data":{"id":%d,"account-number":"5WT0001"}}`, idx))
+		}
+		websocket.Message.Send(conn, `{"type":"AccountBalance","data":{"account-number":"5WT0001"}}`)
+
+		for websocket.Message.Receive(conn, &raw) == nil {
+		}
+	})
+
+	streamer, err := session.NewAccountStreamer(context.Background(), AccountStreamerOpts{
+		BufferSize: 2,
+		Accounts:   []string{"5WT0001"},
+	})
+	if err != nil {
+		t.Fatalf("NewAccountStreamer: %v", err)
+	}
+	defer streamer.Close()
+
+	// the balance is handled after every order so the orders that did not
+	// fit in the buffer have been dropped by the time it arrives
+	select {
+	case <-streamer.Balances():
+	case <-time.After(5 * time.Second):
+		t.Fatal("streamer blocked on the unread orders channel")
+	}
+
+	if dropped := streamer.Dropped(); dropped != 3 {
+		t.Errorf("expected 3 dropped notifications, got %d", dropped)
+	}
+
+	for _, id := range []string{"1", "2"} {
+		if order := <-streamer.Orders(); order.ID != id {
+			t.Errorf("expected buffered order %s, got %s", id, order.ID)
+		}
+	}
+}
+
+func TestAccountStreamerStopsWhenReauthenticationFails(t *testing.T) {
+	testCases := []struct {
+		name                string
+		rememberMeExpiresOn time.Time
+		want                error
+	}{
+		{"remember token expired", time.Now().Add(-time.Hour), ErrRememberTokenExpired},
+		{"remember token rejected", time.Now().Add(time.Hour), ErrInvalidHTTPResponse},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			streamerHandler := websocket.Handler(func(conn *websocket.Conn) {
+				var raw string
+				for websocket.Message.Receive(conn, &raw) == nil {
+				}
+			})
+
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/sessions" {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprint(w, `{"error":{"code":"invalid_credentials"}}`)
+					return
+				}
+				streamerHandler.ServeHTTP(w, r)
+			})
+			session.ExpiresOn = time.Now().Add(-time.Hour)
+			session.RememberToken.Store("remember-me")
+			session.RememberMeExpiresOn = tc.rememberMeExpiresOn
+
+			streamer, err := session.NewAccountStreamer(context.Background(), AccountStreamerOpts{HeartbeatInterval: 10 * time.Millisecond})
+			if err != nil {
+				t.Fatalf("NewAccountStreamer: %v", err)
+			}
+
+			drainErrors(t, streamer)
+
+			if err := streamer.Err(); !errors.Is(err, ErrSessionExpired) || !errors.Is(err, tc.want) {
+				t.Errorf("expected ErrSessionExpired wrapping %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestAccountStreamerUsesDialer(t *testing.T) {
+	session := newStreamerTestSession(t, func(conn *websocket.Conn) {
+		// drop the first connection so the streamer reconnects
+		var raw string
+		websocket.Message.Receive(conn, &raw)
+	})
+
+	var dials []string
+	dialed := make(chan struct{}, 10)
+	dialer := func(url, origin string) (*websocket.Conn, error) {
+		dials = append(dials, url+" "+origin)
+		dialed <- struct{}{}
+		return websocket.Dial(url, "", origin)
+	}
+
+	streamer, err := session.NewAccountStreamer(context.Background(), AccountStreamerOpts{
+		Dialer:         dialer,
+		ReconnectDelay: 10 * time.Millisecond,
+		Accounts:       []string{"5WT0001"},
+	})
+	if err != nil {
+		t.Fatalf("NewAccountStreamer: %v", err)
+	}
+
+	for idx := 0; idx < 2; idx++ {
+		select {
+		case <-dialed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("streamer did not dial")
+		}
+	}
+	streamer.Close()
+
+	want := session.AccountStreamerURL + " " + session.BaseURL
+	if len(dials) < 2 || dials[0] != want || dials[1] != want {
+		t.Errorf("expected the dialer to connect and reconnect to %q, got %v", want, dials)
+	}
+}
+
+func TestAccountStreamerSandboxHandshake(t *testing.T) {
+	testCases := []struct {
+		name        string
+		baseURL     string
+		streamerURL string
+	}{
+		{"production", APIBaseURL, accountStreamerURL},
+		{"sandbox", sandboxAPIBaseURL, sandboxAccountStreamerURL},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			type handshake struct {
+				Action    string   `json:"action"`
+				Value     []string `json:"value"`
+				AuthToken string   `json:"auth-token"`
+			}
+
+			received := make(chan handshake, 1)
+			session := newStreamerTestSession(t, func(conn *websocket.Conn) {
+				var msg handshake
+				if err := websocket.JSON.Receive(conn, &msg); err != nil {
+					t.Errorf("receive handshake: %v", err)
+					return
+				}
+				received <- msg
+
+				var raw string
+				for websocket.Message.Receive(conn, &raw) == nil {
+				}
+			})
+
+			// both environments speak the same protocol so the handshake must
+			// only differ in the URL that is dialed
+			serverURL := session.AccountStreamerURL
+			session.BaseURL = tc.baseURL
+			session.AccountStreamerURL = tc.streamerURL
+
+			var dialed string
+			dialer := func(url, origin string) (*websocket.Conn, error) {
+				dialed = url + " " + origin
+				return websocket.Dial(serverURL, "", origin)
+			}
+
+			streamer, err := session.NewAccountStreamer(context.Background(), AccountStreamerOpts{
+				Dialer:   dialer,
+				Accounts: []string{"5WT0001"},
+			})
+			if err != nil {
+				t.Fatalf("NewAccountStreamer: %v", err)
+			}
+			defer streamer.Close()
+
+			var msg handshake
+			select {
+			case msg = <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("streamer did not send a handshake")
+			}
+
+			if want := tc.streamerURL + " " + tc.baseURL; dialed != want {
+				t.Errorf("expected to dial %q, got %q", want, dialed)
+			}
+
+			want := handshake{Action: "connect", Value: []string{"5WT0001"}, AuthToken: "test-session-token"}
+			if !reflect.DeepEqual(msg, want) {
+				t.Errorf("expected handshake %+v, got %+v", want, msg)
+			}
+		})
+	}
+}
+
+func TestAccountStreamerReconnectDelayJitter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		jitter   float64
+		min, max time.Duration
+	}{
+		{"default", 0, 800 * time.Millisecond, 1200 * time.Millisecond},
+		{"custom", 0.5, 500 * time.Millisecond, 1500 * time.Millisecond},
+		{"disabled", -1, time.Second, time.Second},
+	}
+
+	session := newStreamerTestSession(t, func(conn *websocket.Conn) {
+		var raw string
+		for websocket.Message.Receive(conn, &raw) == nil {
+		}
+	})
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			streamer, err := session.NewAccountStreamer(context.Background(), AccountStreamerOpts{
+				ReconnectDelay:  time.Second,
+				ReconnectJitter: tc.jitter,
+			})
+			if err != nil {
+				t.Fatalf("NewAccountStreamer: %v", err)
+			}
+			defer streamer.Close()
+
+			delays := map[time.Duration]bool{}
+			for idx := 0; idx < 100; idx++ {
+				delay := streamer.reconnectDelay()
+				if delay < tc.min || delay > tc.max {
+					t.Fatalf("delay %s outside of [%s, %s]", delay, tc.min, tc.max)
+				}
+				delays[delay] = true
+			}
+
+			if tc.min != tc.max && len(delays) < 2 {
+				t.Errorf("expected reconnect delays to vary, got %v", delays)
+			}
+		})
+	}
+}
+
+func TestAccountStreamerWatchOrder(t *testing.T) {
+	session := newStreamerTestSession(t, func(conn *websocket.Conn) {
+		var raw string
+		if websocket.Message.Receive(conn, &raw) != nil {
+			return
+		}
+
+		for _, event := range []string{
+			`{"type":"Order","data":{"id":1,"account-number":"5WT0001","status":"Live"}}`,
+			`{"type":"Order","data":{"id":2,"account-number":"5WT0001","status":"Live"}}`,
+			`{"type":"Order","data":{"id":1,"account-number":"5WT0001","status":"Filled"}}`,
+			`{"type":"Order","data":{"id":2,"account-number":"5WT0001","status":"Cancelled"}}`,
+		} {
+			websocket.Message.Send(conn, event)
+		}
+
+		for websocket.Message.Receive(conn, &raw) == nil {
+		}
+	})
+
+	streamer, err := session.NewAccountStreamer(context.Background())
+	if err != nil {
+		t.Fatalf("NewAccountStreamer: %v", err)
+	}
+	defer streamer.Close()
+
+	watched := streamer.WatchOrder("1")
+	if err := streamer.SubscribeAccounts([]string{"5WT0001"}); err != nil {
+		t.Fatalf("SubscribeAccounts: %v", err)
+	}
+
+	var statuses []string
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case orderStatus, ok := <-watched:
+			if !ok {
+				done = true
+				break
+			}
+			if orderStatus.ID != "1" {
+				t.Errorf("unexpected update for order %s", orderStatus.ID)
+			}
+			statuses = append(statuses, orderStatus.Status)
+		case <-timeout:
+			t.Fatal("watch channel was not closed after the order filled")
+		}
+	}
+
+	if !reflect.DeepEqual(statuses, []string{"Live", "Filled"}) {
+		t.Errorf("expected Live then Filled, got %v", statuses)
+	}
+
+	// every update is still delivered on the orders channel
+	for idx := 0; idx < 4; idx++ {
+		<-streamer.Orders()
+	}
+}
+
+func TestAccountStreamerWatchOrderClosedWithStreamer(t *testing.T) {
+	session := newStreamerTestSession(t, func(conn *websocket.Conn) {
+		var raw string
+		for websocket.Message.Receive(conn, &raw) == nil {
+		}
+	})
+
+	streamer, err := session.NewAccountStreamer(context.Background())
+	if err != nil {
+		t.Fatalf("NewAccountStreamer: %v", err)
+	}
+
+	watched := streamer.WatchOrder("1")
+	streamer.Close()
+
+	if _, ok := <-watched; ok {
+		t.Error("expected the watch channel to be closed")
+	}
+
+	if _, ok := <-streamer.WatchOrder("2"); ok {
+		t.Error("expected watching a stopped streamer to return a closed channel")
+	}
+}
+
+func TestAccountStreamerExportImportSubscriptions(t *testing.T) {
+	connectHandler := func(subscribed chan<- []string) func(*websocket.Conn) {
+		return func(conn *websocket.Conn) {
+			for {
+				var msg struct {
+					Action string   `json:"action"`
+					Value  []string `json:"value"`
+				}
+				if err := websocket.JSON.Receive(conn, &msg); err != nil {
+					return
+				}
+				if msg.Action == "connect" {
+					subscribed <- msg.Value
+				}
+			}
+		}
+	}
+
+	previous, err := newStreamerTestSession(t, connectHandler(make(chan []string, 10))).NewAccountStreamer(context.Background())
+	if err != nil {
+		t.Fatalf("NewAccountStreamer: %v", err)
+	}
+
+	if err := previous.SubscribeAccounts([]string{"5WT0001", "5WT0002"}); err != nil {
+		t.Fatalf("SubscribeAccounts: %v", err)
+	}
+
+	exported := previous.ExportSubscriptions()
+	previous.Close()
+
+	want := []Subscription{{AccountNumber: "5WT0001"}, {AccountNumber: "5WT0002"}}
+	if !reflect.DeepEqual(exported, want) {
+		t.Fatalf("expected %v, got %v", want, exported)
+	}
+
+	subscribed := make(chan []string, 10)
+	streamer, err := newStreamerTestSession(t, connectHandler(subscribed)).NewAccountStreamer(context.Background())
+	if err != nil {
+		t.Fatalf("NewAccountStreamer: %v", err)
+	}
+	defer streamer.Close()
+
+	if err := streamer.ImportSubscriptions(exported); err != nil {
+		t.Fatalf("ImportSubscriptions: %v", err)
+	}
+
+	select {
+	case got := <-subscribed:
+		if want := []string{"5WT0001", "5WT0002"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected connect for %v, got %v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("streamer did not subscribe")
+	}
+
+	if got := streamer.ExportSubscriptions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected imported subscriptions %v, got %v", want, got)
+	}
+}
@@ -20,9 +20,16 @@ package gotasty
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -44,10 +51,73 @@ const (
 	accountStreamerURL        = "wss://streamer.tastyworks.com"
 )
 
+// occSymbolRegex matches OCC option symbols, e.g. `AAPL  191004P00275000`
+var occSymbolRegex = regexp.MustCompile(`^([A-Z0-9.]{1,6})\s*(\d{6})([CP])(\d{8})$`)
+
+// maxMarketDataSymbols is the maximum number of symbols per market-data request
+const maxMarketDataSymbols = 100
+
+// endpointRoutes are the parameterized routes reported to a RequestObserver
+var endpointRoutes = []string{
+	"/accounts/{account}/balance-snapshots",
+	"/accounts/{account}/balances",
+	"/accounts/{account}/orders",
+	"/accounts/{account}/orders/dry-run",
+	"/accounts/{account}/orders/live",
+	"/accounts/{account}/orders/{id}",
+	"/accounts/{account}/positions",
+	"/accounts/{account}/trading-status",
+	"/accounts/{account}/transactions",
+	"/customers/{customer}/accounts",
+	"/customers/me/accounts/{account}",
+	"/instruments/cryptocurrencies/{symbol}",
+	"/instruments/equities/{symbol}",
+	"/instruments/equity-options/{symbol}",
+	"/instruments/future-options/{symbol}",
+	"/instruments/futures/{symbol}",
+	"/option-chains/{symbol}",
+}
+
+// defaultMaxBackoff is the longest WaitForOrder waits between failed polls
+const defaultMaxBackoff = time.Minute
+
+// multiplierCache stores contract multipliers keyed by instrument type and symbol
+var multiplierCache sync.Map
+
+// streamerSymbolCache stores futures streamer symbols keyed by trading symbol
+var streamerSymbolCache sync.Map
+
+// apiBaseURL is the production API used by NewSession and NewOAuthSession;
+// tests point it at a local server
+var apiBaseURL = APIBaseURL
+
 var (
 	ErrSessionExpired       = errors.New("session token is expired")
 	ErrRememberTokenExpired = errors.New("remember-me token is expired")
 	ErrInvalidHTTPResponse  = errors.New("invalid HTTP response received")
+	ErrInvalidSessionData   = errors.New("failed to decode session data")
+	ErrResponseTooLarge     = errors.New("response body exceeds maximum size")
+	ErrNotAuthorized        = errors.New("session is not authorized for this request")
+	ErrAccountNotFound      = errors.New("account not found")
+	ErrNoDefaultAccount     = errors.New("customer does not have a single default account")
+
+	ErrUnsupportedInstrumentType = errors.New("instrument type is not supported")
+	ErrNoPriceAvailable          = errors.New("no price available for symbol")
+	ErrLotQuantityMismatch       = errors.New("lot quantities do not match transaction quantity")
+	ErrInvalidOrder              = errors.New("invalid order")
+	ErrLegPriceNotSupported      = errors.New("per-leg prices are only supported on multi-leg limit orders")
+	ErrNoRollCandidate           = errors.New("no roll candidate found")
+	ErrNoGreeksAvailable         = errors.New("no greeks available for symbol")
+	ErrUnknownTransactionType    = errors.New("unknown transaction type")
+	ErrUnknownTransactionSubType = errors.New("unknown transaction sub-type")
+	ErrUnknownEffect             = errors.New("unknown effect")
+	ErrFlattenNotConfirmed       = errors.New("flattening an account must be confirmed")
+	ErrInvalidOptionSymbol       = errors.New("invalid OCC option symbol")
+	ErrOrderNotAccepted          = errors.New("order was not accepted")
+	ErrNoServerTime              = errors.New("response did not include a server time")
+	ErrStreamerDisconnected      = errors.New("account streamer disconnected")
+	ErrStreamerRequestFailed     = errors.New("account streamer request failed")
+	ErrUndefinedRisk             = errors.New("order has undefined risk")
 )
 
 // NewSession obtains a session token and optionally a remember-me token from the
@@ -67,7 +137,7 @@ func NewSession(login, password string, opts ...SessionOpts) (*Session, error) {
 		"User-Agent":   userAgent,
 	})
 
-	url := APIBaseURL
+	url := apiBaseURL
 	accountStreamerURL := accountStreamerURL
 	if opt.Sandbox {
 		url = sandboxAPIBaseURL
@@ -75,6 +145,8 @@ func NewSession(login, password string, opts ...SessionOpts) (*Session, error) {
 	}
 
 	client.SetBaseURL(url)
+	observeRequests(client, opt.Observer)
+	limitResponseSize(client, opt.MaxResponseBytes)
 
 	resp, err := client.R().
 		SetBody(User{Username: login, Password: password, RememberMe: opt.RememberMe}).
@@ -99,12 +171,19 @@ func NewSession(login, password string, opts ...SessionOpts) (*Session, error) {
 		Token:         &atomic.Value{},
 		RememberToken: &atomic.Value{},
 
+		defaultAccount: &atomic.Pointer[Account]{},
+
 		RefreshLocker: &sync.Mutex{},
 		Debug:         opt.Debug,
+		Observer:      opt.Observer,
+		Auditor:       opt.Auditor,
+
+		MaxResponseBytes: opt.MaxResponseBytes,
 	}
 
 	body := string(resp.Body())
 	session.Token.Store(gjson.Get(body, "data.session-token").String())
+	session.RememberToken.Store("")
 
 	if opt.RememberMe {
 		session.RememberMeExpiresOn = resp.ReceivedAt().Add(28 * 24 * time.Hour)
@@ -119,6 +198,65 @@ func NewSession(login, password string, opts ...SessionOpts) (*Session, error) {
 	return session, nil
 }
 
+// NewOAuthSession creates a session that authenticates with an OAuth client
+// secret and refresh token instead of a username and password. Access tokens
+// are short-lived and are automatically refreshed using the refresh token.
+func NewOAuthSession(clientSecret, refreshToken string, opts ...SessionOpts) (*Session, error) {
+	var opt SessionOpts
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	client := resty.New()
+
+	client.SetDebug(opt.Debug)
+	client.SetHeaders(map[string]string{
+		"Content-Type": "application/json",
+		"User-Agent":   userAgent,
+	})
+
+	url := apiBaseURL
+	accountStreamerURL := accountStreamerURL
+	if opt.Sandbox {
+		url = sandboxAPIBaseURL
+		accountStreamerURL = sandboxAccountStreamerURL
+	}
+
+	client.SetBaseURL(url)
+	observeRequests(client, opt.Observer)
+	limitResponseSize(client, opt.MaxResponseBytes)
+
+	session := &Session{
+		AccountStreamerURL: accountStreamerURL,
+		BaseURL:            url,
+
+		ClientSecret:      clientSecret,
+		OAuthRefreshToken: refreshToken,
+
+		Token:         &atomic.Value{},
+		RememberToken: &atomic.Value{},
+
+		defaultAccount: &atomic.Pointer[Account]{},
+
+		RefreshLocker: &sync.Mutex{},
+		Debug:         opt.Debug,
+		Observer:      opt.Observer,
+		Auditor:       opt.Auditor,
+
+		MaxResponseBytes: opt.MaxResponseBytes,
+	}
+
+	session.RememberToken.Store("")
+
+	if err := session.refreshOAuthToken(client); err != nil {
+		return nil, err
+	}
+
+	session.AuthenticatedOn = time.Now()
+
+	return session, nil
+}
+
 // NewSessionFromBytes constructs a session object from the serialized bytes
 func NewSessionFromBytes(sessionData []byte) (*Session, error) {
 	var data struct {
@@ -135,7 +273,11 @@ func NewSessionFromBytes(sessionData []byte) (*Session, error) {
 		ExternalID string `json:"external-id"`
 		Username   string `json:"username"`
 
-		Debug bool `json:"debug"`
+		ClientSecret      string `json:"client-secret"`
+		OAuthRefreshToken string `json:"oauth-refresh-token"`
+
+		Debug            bool  `json:"debug"`
+		MaxResponseBytes int64 `json:"max-response-bytes"`
 	}
 
 	buf := bytes.NewBuffer(sessionData)
@@ -147,7 +289,11 @@ func NewSessionFromBytes(sessionData []byte) (*Session, error) {
 
 	decoder := json.NewDecoder(uncompress)
 	if err := decoder.Decode(&data); err != nil {
-		return nil, err
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("%w at byte offset %d: %w", ErrInvalidSessionData, syntaxErr.Offset, err)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSessionData, err)
 	}
 
 	session := &Session{
@@ -158,9 +304,16 @@ func NewSessionFromBytes(sessionData []byte) (*Session, error) {
 		Username:   data.Username,
 		Debug:      data.Debug,
 
+		ClientSecret:      data.ClientSecret,
+		OAuthRefreshToken: data.OAuthRefreshToken,
+
+		MaxResponseBytes: data.MaxResponseBytes,
+
 		Token:         &atomic.Value{},
 		RememberToken: &atomic.Value{},
 
+		defaultAccount: &atomic.Pointer[Account]{},
+
 		RefreshLocker: &sync.Mutex{},
 	}
 
@@ -184,6 +337,9 @@ func NewSessionFromBytes(sessionData []byte) (*Session, error) {
 
 // Marshal serializes the Session object as a JSON string
 func (session *Session) Marshal() ([]byte, error) {
+	// copies made by WithHeader store the expiry state of the original session
+	owner := session.tokenOwner()
+
 	var out bytes.Buffer
 
 	compressor, err := zstd.NewWriter(&out)
@@ -207,14 +363,18 @@ func (session *Session) Marshal() ([]byte, error) {
 		ExternalID string `json:"external-id"`
 		Username   string `json:"username"`
 
-		Debug bool `json:"debug"`
+		ClientSecret      string `json:"client-secret"`
+		OAuthRefreshToken string `json:"oauth-refresh-token"`
+
+		Debug            bool  `json:"debug"`
+		MaxResponseBytes int64 `json:"max-response-bytes"`
 	}{
-		AuthenticatedOn:   session.AuthenticatedOn.Unix(),
+		AuthenticatedOn:   owner.AuthenticatedOn.Unix(),
 		BaseURL:           session.BaseURL,
-		SessionToken:      session.Token.Load().(string),
-		ExpiresOn:         session.ExpiresOn.Unix(),
-		RememberToken:     session.RememberToken.Load().(string),
-		RememberExpiresOn: session.RememberMeExpiresOn.Unix(),
+		SessionToken:      loadToken(session.Token),
+		ExpiresOn:         owner.ExpiresOn.Unix(),
+		RememberToken:     loadToken(session.RememberToken),
+		RememberExpiresOn: owner.RememberMeExpiresOn.Unix(),
 
 		Name:       session.Name,
 		Nickname:   session.Nickname,
@@ -222,7 +382,11 @@ func (session *Session) Marshal() ([]byte, error) {
 		ExternalID: session.ExternalID,
 		Username:   session.Username,
 
-		Debug: session.Debug,
+		ClientSecret:      session.ClientSecret,
+		OAuthRefreshToken: owner.OAuthRefreshToken,
+
+		Debug:            session.Debug,
+		MaxResponseBytes: session.MaxResponseBytes,
 	})
 
 	if err != nil {
@@ -236,6 +400,50 @@ func (session *Session) Marshal() ([]byte, error) {
 	return out.Bytes(), nil
 }
 
+// Reauthenticate logs in again with the session's username and password and
+// replaces the session and remember-me tokens. This recovers a session whose
+// tokens have both expired, e.g. one restored with NewSessionFromBytes.
+func (session *Session) Reauthenticate(password string) error {
+	if session.owner != nil {
+		return session.owner.Reauthenticate(password)
+	}
+
+	session.RefreshLocker.Lock()
+	defer session.RefreshLocker.Unlock()
+
+	client := resty.New()
+	client.SetBaseURL(session.BaseURL)
+	client.SetDebug(session.Debug)
+	client.SetHeaders(map[string]string{
+		"Content-Type": "application/json",
+		"User-Agent":   userAgent,
+	})
+	observeRequests(client, session.Observer)
+	limitResponseSize(client, session.MaxResponseBytes)
+
+	resp, err := client.R().
+		SetBody(User{Username: session.Username, Password: password, RememberMe: true}).
+		Post("/sessions")
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return fmt.Errorf("%w %s: %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	body := string(resp.Body())
+
+	session.AuthenticatedOn = resp.ReceivedAt()
+	session.ExpiresOn = resp.ReceivedAt().Add(24 * time.Hour)
+	session.Token.Store(gjson.Get(body, "data.session-token").String())
+
+	session.RememberMeExpiresOn = resp.ReceivedAt().Add(28 * 24 * time.Hour)
+	session.RememberToken.Store(gjson.Get(body, "data.remember-token").String())
+
+	return nil
+}
+
 // Delete invalidates the session token and remember token so they may no-longer be used
 func (session *Session) Delete() error {
 	client, err := session.restyClient()
@@ -255,6 +463,44 @@ func (session *Session) Delete() error {
 	return nil
 }
 
+// WithHeader returns a copy of the session that adds the header key: value to
+// every request it makes. The copy shares its tokens with the original session
+// and refreshes them through it, so the original's ExpiresOn and Status stay
+// current; the copy's own ExpiresOn and RememberMeExpiresOn fields are not
+// updated.
+func (session *Session) WithHeader(key, value string) *Session {
+	clone := *session
+	clone.owner = session.tokenOwner()
+	clone.headers = make(map[string]string, len(session.headers)+1)
+	for k, v := range session.headers {
+		clone.headers[k] = v
+	}
+	clone.headers[key] = value
+
+	return &clone
+}
+
+// AuthorizationHeader returns the value of the Authorization header sent with
+// API requests, refreshing the session token first if it has expired. This
+// is useful for calling the API with other tools such as curl.
+func (session *Session) AuthorizationHeader() (string, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return "", err
+	}
+
+	return client.Header.Get("Authorization"), nil
+}
+
+// tokenOwner returns the session that refreshes this session's tokens: the
+// session a WithHeader copy was made from, or the session itself
+func (session *Session) tokenOwner() *Session {
+	if session.owner != nil {
+		return session.owner
+	}
+	return session
+}
+
 func (session *Session) restyClient() (*resty.Client, error) {
 	client := resty.New()
 	client.SetBaseURL(session.BaseURL)
@@ -263,68 +509,324 @@ func (session *Session) restyClient() (*resty.Client, error) {
 		"User-Agent":   userAgent,
 	})
 
+	client.SetHeaders(session.headers)
+	observeRequests(client, session.Observer)
+	limitResponseSize(client, session.MaxResponseBytes)
+
 	client.SetDebug(session.Debug)
-	client.SetDebug(true)
 
-	// check if the session token is expired
-	// NOTE: add a 5 minute buffer to ensure that the token doesn't expire mid-use
-	if session.ExpiresOn.Before(time.Now().Add(-5 * time.Minute)) {
-		session.RefreshLocker.Lock()
-		defer session.RefreshLocker.Unlock()
+	// check if the session token is expired; copies made by WithHeader
+	// refresh through the session they were copied from so the expiry
+	// time and single-use remember-me token stay consistent between them
+	// NOTE: refresh 5 minutes early to ensure that the token doesn't expire mid-use
+	owner := session.tokenOwner()
+	owner.RefreshLocker.Lock()
+	defer owner.RefreshLocker.Unlock()
+
+	// the expiry is checked while holding the lock so that concurrent
+	// requests waiting on a refresh see the new expiry and don't refresh again
+	if owner.ExpiresOn.Before(time.Now().Add(5 * time.Minute)) {
+		log.Debug().Time("TokenExpires", owner.ExpiresOn).
+			Time("RememberTokenExpires", owner.RememberMeExpiresOn).Msg("session token is expiring")
+
+		// sessions created with OAuth exchange their refresh token for a new access token
+		if owner.OAuthRefreshToken != "" {
+			if err := owner.refreshOAuthToken(client); err != nil {
+				return nil, err
+			}
+		} else if err := owner.exchangeRememberToken(client); err != nil {
+			return nil, err
+		}
+	}
+
+	client.SetHeader("Authorization", loadToken(session.Token))
+
+	return client, nil
+}
+
+// exchangeRememberToken trades the remember-me token for a new session token
+func (session *Session) exchangeRememberToken(client *resty.Client) error {
+	rememberMe := loadToken(session.RememberToken)
+
+	// if no remember-me token available return an error
+	if rememberMe == "" {
+		return ErrSessionExpired
+	}
+
+	// there is a remember-me token, check if it's expired
+	if session.RememberMeExpiresOn.Before(time.Now()) {
+		return ErrRememberTokenExpired
+	}
+
+	// there is a valid remember-me token, exchange it for a session token
+	resp, err := client.R().
+		SetBody(User{Username: session.Username, RememberToken: rememberMe, RememberMe: true}).
+		Post("/sessions")
+	if err != nil {
+		return err
+	}
+
+	// the remember-me token was rejected, logging in again is required
+	if resp.StatusCode() == http.StatusUnauthorized {
+		return fmt.Errorf("%w: %w %s: %s", ErrRememberTokenExpired, ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	if resp.StatusCode() >= 400 {
+		return fmt.Errorf("%w %s: %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	body := string(resp.Body())
+
+	session.ExpiresOn = resp.ReceivedAt().Add(24 * time.Hour)
+	session.Token.Store(gjson.Get(body, "data.session-token").String())
+
+	session.RememberMeExpiresOn = resp.ReceivedAt().Add(28 * 24 * time.Hour)
+	session.RememberToken.Store(gjson.Get(body, "data.remember-token").String())
+
+	return nil
+}
+
+// refreshOAuthToken trades the OAuth refresh token for a new access token
+func (session *Session) refreshOAuthToken(client *resty.Client) error {
+	resp, err := client.R().
+		SetBody(map[string]string{
+			"grant_type":    "refresh_token",
+			"refresh_token": session.OAuthRefreshToken,
+			"client_secret": session.ClientSecret,
+		}).
+		Post("/oauth/token")
+	if err != nil {
+		return err
+	}
+
+	// the refresh token was revoked or has expired
+	if resp.StatusCode() == http.StatusUnauthorized {
+		return fmt.Errorf("%w: %w %s (oauth): %s", ErrSessionExpired, ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	if resp.StatusCode() >= 400 {
+		return fmt.Errorf("%w %s (oauth): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	body := string(resp.Body())
+
+	tokenType := gjson.Get(body, "token_type").String()
+	if tokenType == "" {
+		tokenType = "Bearer"
+	}
+
+	session.ExpiresOn = resp.ReceivedAt().Add(time.Duration(gjson.Get(body, "expires_in").Int()) * time.Second)
+	session.Token.Store(fmt.Sprintf("%s %s", tokenType, gjson.Get(body, "access_token").String()))
+
+	return nil
+}
+
+// observeRequests reports the start and end of each request made by client to observer
+func observeRequests(client *resty.Client, observer RequestObserver) {
+	if observer == nil {
+		return
+	}
 
-		log.Debug().Time("TokenExpires", session.ExpiresOn).
-			Time("RememberTokenExpires", session.RememberMeExpiresOn).Msg("session token is expired")
+	client.OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+		observer.OnRequestStart(endpointRoute(req.URL))
+		return nil
+	})
 
-		rememberMe := session.RememberToken.Load().(string)
+	client.OnSuccess(func(_ *resty.Client, resp *resty.Response) {
+		observer.OnRequestEnd(endpointRoute(resp.Request.URL), resp.Time(), resp.StatusCode(), nil)
+	})
 
-		// if no remember-me token available return an error
-		if rememberMe == "" {
-			return nil, ErrSessionExpired
+	client.OnError(func(req *resty.Request, err error) {
+		var dur time.Duration
+		if !req.Time.IsZero() {
+			dur = time.Since(req.Time)
 		}
 
-		// there is a remember-me token, check if it's expired
-		if session.RememberMeExpiresOn.Before(time.Now()) {
-			return nil, ErrRememberTokenExpired
+		statusCode := 0
+		var respErr *resty.ResponseError
+		if errors.As(err, &respErr) {
+			statusCode = respErr.Response.StatusCode()
 		}
 
-		// there is a valid remember-me token, exchange it for a session token
-		resp, err := client.R().
-			SetBody(User{Username: session.Username, RememberToken: session.RememberToken.Load().(string), RememberMe: true}).
-			Post("/sessions")
-		if err != nil {
-			return nil, err
+		observer.OnRequestEnd(endpointRoute(req.URL), dur, statusCode, err)
+	})
+}
+
+// limitResponseSize makes requests sent by client fail with
+// ErrResponseTooLarge if the response body is larger than maxBytes. A
+// maxBytes of 0 or less disables the limit.
+func limitResponseSize(client *resty.Client, maxBytes int64) {
+	if maxBytes <= 0 {
+		return
+	}
+
+	client.SetTransport(&limitedTransport{
+		base:     client.GetClient().Transport,
+		maxBytes: maxBytes,
+	})
+}
+
+type limitedTransport struct {
+	base     http.RoundTripper
+	maxBytes int64
+}
+
+func (transport *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := transport.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.ContentLength > transport.maxBytes {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrResponseTooLarge, resp.ContentLength, transport.maxBytes)
+	}
+
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: transport.maxBytes}
+	return resp, nil
+}
+
+// limitedBody returns an error once more than remaining bytes have been read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	if body.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+
+	// read one byte past the limit to detect oversized bodies
+	if int64(len(p)) > body.remaining+1 {
+		p = p[:body.remaining+1]
+	}
+
+	n, err := body.ReadCloser.Read(p)
+	body.remaining -= int64(n)
+	if body.remaining < 0 {
+		return n, ErrResponseTooLarge
+	}
+
+	return n, err
+}
+
+// endpointPath strips the scheme, host, and query from rawURL
+func endpointPath(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	return parsed.Path
+}
+
+// endpointRoute returns the route template, e.g. /accounts/{account}/orders/{id},
+// that matches the path of rawURL so that observers do not see a separate
+// endpoint for every account, order, or symbol. Paths without parameters are
+// returned unchanged.
+func endpointRoute(rawURL string) string {
+	path := endpointPath(rawURL)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	route, bestLiterals := path, -1
+	for _, candidate := range endpointRoutes {
+		// prefer the most specific route, e.g. /orders/live over /orders/{id}
+		if literals, ok := matchRoute(candidate, segments); ok && literals > bestLiterals {
+			route, bestLiterals = candidate, literals
 		}
+	}
 
-		if resp.StatusCode() >= 400 {
-			return nil, fmt.Errorf("%w %s: %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	return route
+}
+
+// matchRoute returns true and the number of literal segments in route if
+// route matches the path segments. A parameter at the end of route matches
+// the rest of the path since symbols, e.g. BTC/USD, may contain a slash.
+func matchRoute(route string, segments []string) (int, bool) {
+	parts := strings.Split(strings.Trim(route, "/"), "/")
+	if len(segments) < len(parts) {
+		return 0, false
+	}
+
+	literals := 0
+	for idx, part := range parts {
+		if strings.HasPrefix(part, "{") {
+			if idx == len(parts)-1 {
+				return literals, true
+			}
+			continue
 		}
 
-		body := string(resp.Body())
+		if part != segments[idx] {
+			return 0, false
+		}
+		literals++
+	}
 
-		session.ExpiresOn = resp.ReceivedAt().Add(24 * time.Hour)
-		session.Token.Store(gjson.Get(body, "data.session-token").String())
+	return literals, len(segments) == len(parts)
+}
 
-		session.RememberMeExpiresOn = resp.ReceivedAt().Add(28 * 24 * time.Hour)
-		session.RememberToken.Store(gjson.Get(body, "data.session-token").String())
+// ServerTime returns the tastytrade server's current time. The API does not
+// have a dedicated time endpoint so the time is read from the Date header of
+// a lightweight request and has a resolution of one second. The clock skew
+// of the local machine is ServerTime() - time.Now().
+func (session *Session) ServerTime() (time.Time, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return time.Time{}, err
 	}
 
-	client.SetHeader("Authorization", session.Token.Load().(string))
+	resp, err := client.R().Get("/customers/me")
+	if err != nil {
+		return time.Time{}, err
+	}
 
-	return client, nil
+	if resp.StatusCode() >= 400 {
+		return time.Time{}, fmt.Errorf("%w %s (server time): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	date := resp.Header().Get("Date")
+	if date == "" {
+		return time.Time{}, ErrNoServerTime
+	}
+
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %w", ErrNoServerTime, err)
+	}
+
+	return serverTime, nil
 }
 
 // Accounts returns a list of accounts held by the customer
 func (session *Session) Accounts() ([]*Account, error) {
+	return session.customerAccounts("me")
+}
+
+// CustomerAccounts returns the accounts held by customerID. This is intended
+// for advisors that manage accounts on behalf of other customers; if the
+// session is not authorized to view the customer's accounts an error
+// wrapping ErrNotAuthorized is returned.
+func (session *Session) CustomerAccounts(customerID string) ([]*Account, error) {
+	return session.customerAccounts(url.PathEscape(customerID))
+}
+
+func (session *Session) customerAccounts(customerID string) ([]*Account, error) {
 	client, err := session.restyClient()
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := client.R().Get("/customers/me/accounts")
+	resp, err := client.R().Get(fmt.Sprintf("/customers/%s/accounts", customerID))
 	if err != nil {
 		return nil, err
 	}
 
+	if resp.StatusCode() == http.StatusForbidden {
+		return nil, fmt.Errorf("%w %s (accounts): %s", ErrNotAuthorized, resp.Status(), resp.Body())
+	}
+
 	if resp.StatusCode() >= 400 {
 		return nil, fmt.Errorf("%w %s (accounts): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
 	}
@@ -332,543 +834,1761 @@ func (session *Session) Accounts() ([]*Account, error) {
 	arr := gjson.Get(string(resp.Body()), "data.items").Array()
 	accounts := make([]*Account, len(arr))
 	for idx, acct := range arr {
-		accounts[idx] = &Account{
-			AccountNumber:     acct.Get("account.account-number").String(),
-			ExternalID:        acct.Get("account.external-id").String(),
-			OpenedAt:          acct.Get("account.opened-at").Time(),
-			Nickname:          acct.Get("account.nickname").String(),
-			AccountType:       acct.Get("account.account-type-name").String(),
-			DayTraderStatus:   acct.Get("account.day-trader-status").Bool(),
-			MarginOrCash:      acct.Get("account.margin-or-cash").String(),
-			AuthorityLevel:    acct.Get("authority-level").String(),
-			IsFirmError:       acct.Get("account.is-firm-error").Bool(),
-			IsFirmProprietary: acct.Get("account.is-firm-proprietary").Bool(),
-			IsTestDrive:       acct.Get("account.is-test-drive").Bool(),
-			IsForeign:         acct.Get("account.is-foreign").Bool(),
-			FundingDate:       acct.Get("account.funding-date").Time(),
-		}
+		accounts[idx] = parseAccount(acct)
 	}
 
 	return accounts, nil
 }
 
-// Balance returns the current balance values for an account
-func (session *Session) Balance(accountNumber string) (*Balance, error) {
-	client, err := session.restyClient()
+// DefaultAccount returns the customer's account when they have only one, or
+// the only account they own when they also have access to accounts owned by
+// others. ErrNoDefaultAccount is returned if no single account can be
+// chosen. The result is cached for the life of the session.
+func (session *Session) DefaultAccount() (*Account, error) {
+	if session.defaultAccount != nil {
+		if account := session.defaultAccount.Load(); account != nil {
+			return account, nil
+		}
+	}
+
+	accounts, err := session.Accounts()
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := client.R().Get(fmt.Sprintf("/accounts/%s/balances", accountNumber))
-	if err != nil {
-		return nil, err
-	}
+	var account *Account
+	if len(accounts) == 1 {
+		account = accounts[0]
+	} else {
+		for _, candidate := range accounts {
+			if candidate.AuthorityLevel != Owner {
+				continue
+			}
+
+			if account != nil {
+				return nil, fmt.Errorf("%w: customer owns %s and %s", ErrNoDefaultAccount, account.AccountNumber, candidate.AccountNumber)
+			}
+
+			account = candidate
+		}
+	}
+
+	if account == nil {
+		return nil, fmt.Errorf("%w: found %d accounts", ErrNoDefaultAccount, len(accounts))
+	}
+
+	if session.defaultAccount != nil {
+		session.defaultAccount.Store(account)
+	}
+
+	return account, nil
+}
+
+// SetAccountNickname changes the customer assigned nickname of an account
+func (session *Session) SetAccountNickname(accountNumber, nickname string) error {
+	client, err := session.restyClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.R().
+		SetBody(map[string]string{"nickname": nickname}).
+		Patch(fmt.Sprintf("/customers/me/accounts/%s", accountNumber))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return fmt.Errorf("%w %s (account nickname): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	return nil
+}
+
+// Balance returns the current balance values for an account
+func (session *Session) Balance(accountNumber string) (*Balance, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.R().Get(fmt.Sprintf("/accounts/%s/balances", accountNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return nil, accountHTTPError(resp, "balances")
+	}
+
+	return parseBalance(gjson.Get(string(resp.Body()), "data")), nil
+}
+
+// CheckDayTrade dry-runs order and compares the buying power it would use with
+// the account's day-trading buying power to warn before placing a day trade
+// that could result in a day-trade call. Orders that release buying power
+// have no cost.
+func (session *Session) CheckDayTrade(accountNumber string, order *Order) (*DayTradeCheck, error) {
+	balance, err := session.Balance(accountNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun, err := session.DryRunOrder(accountNumber, order)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &DayTradeCheck{
+		DayTradingBuyingPower: balance.DayTradingBuyingPower,
+		DryRun:                dryRun,
+	}
+
+	if effect := dryRun.EffectOnBuyingPower; effect != nil && effect.ChangeInBuyingPowerEffect == Debit {
+		check.OrderCost = effect.ChangeInBuyingPower
+	}
+
+	check.CanDayTrade = balance.CanDayTrade(check.OrderCost)
+
+	return check, nil
+}
+
+// TradingStatus returns the account's trading restrictions, such as its
+// pattern day trader status and day-trade count
+func (session *Session) TradingStatus(accountNumber string) (*TradingStatus, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.R().Get(fmt.Sprintf("/accounts/%s/trading-status", accountNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return nil, fmt.Errorf("%w %s (trading status): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	status := gjson.Get(string(resp.Body()), "data")
+	return &TradingStatus{
+		AccountNumber:                status.Get("account-number").String(),
+		DayTradeCount:                int(status.Get("day-trade-count").Int()),
+		IsPatternDayTrader:           status.Get("is-pattern-day-trader").Bool(),
+		IsFrozen:                     status.Get("is-frozen").Bool(),
+		IsClosed:                     status.Get("is-closed").Bool(),
+		IsClosingOnly:                status.Get("is-closing-only").Bool(),
+		IsPortfolioMarginEligibleRaw: status.Get("is-portfolio-margin-eligible").Bool(),
+		IsPortfolioMarginEnabled:     status.Get("is-portfolio-margin-enabled").Bool(),
+		PDTResetOn:                   asDate(status.Get("pdt-reset-on").String()),
+		UpdatedAt:                    status.Get("updated-at").Time(),
+	}, nil
+}
+
+// DayTradeCount returns the number of day trades made by the account in the
+// current rolling 5 business day window
+func (session *Session) DayTradeCount(accountNumber string) (int, error) {
+	status, err := session.TradingStatus(accountNumber)
+	if err != nil {
+		return 0, err
+	}
+
+	return status.DayTradeCount, nil
+}
+
+// PortfolioMarginStatus returns whether the account is eligible for portfolio
+// margin and whether it is currently enabled
+func (session *Session) PortfolioMarginStatus(accountNumber string) (*PortfolioMarginStatus, error) {
+	status, err := session.TradingStatus(accountNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortfolioMarginStatus{
+		AccountNumber: status.AccountNumber,
+		Eligible:      status.IsPortfolioMarginEligible(),
+		Enabled:       status.IsPortfolioMarginEnabled,
+	}, nil
+}
+
+// BalanceSnapshot returns a snapshot of the account balance at the specified time
+func (session *Session) BalanceSnapshot(accountNumber string, timeOfDay TimeOfDay, snapshotDate time.Time) (*Balance, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.R().
+		SetQueryParam("snapshot-date", snapshotDate.Format(time.RFC3339)).
+		SetQueryParam("time-of-day", timeOfDay.String()).
+		Get(fmt.Sprintf("/accounts/%s/balance-snapshots", accountNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return nil, fmt.Errorf("%w %s (balance-snapshots): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	return parseBalance(gjson.Get(string(resp.Body()), "data")), nil
+}
+
+// BalanceSnapshots returns the account's balance snapshots taken at timeOfDay
+// on each day between start and end, inclusive
+func (session *Session) BalanceSnapshots(accountNumber string, start, end time.Time, timeOfDay TimeOfDay) ([]*Balance, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]*Balance, 0)
+	for pageOffset, totalPages := 0, 1; pageOffset < totalPages; pageOffset++ {
+		resp, err := client.R().
+			SetQueryParam("start-date", start.Format("2006-01-02")).
+			SetQueryParam("end-date", end.Format("2006-01-02")).
+			SetQueryParam("time-of-day", timeOfDay.String()).
+			SetQueryParam("page-offset", fmt.Sprint(pageOffset)).
+			Get(fmt.Sprintf("/accounts/%s/balance-snapshots", accountNumber))
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode() >= 400 {
+			return nil, fmt.Errorf("%w %s (balance-snapshots): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+		}
+
+		body := string(resp.Body())
+		for _, item := range gjson.Get(body, "data.items").Array() {
+			balances = append(balances, parseBalance(item))
+		}
+
+		totalPages = int(gjson.Get(body, "pagination.total-pages").Int())
+	}
+
+	return balances, nil
+}
+
+// Positions returns a list of the accounts positions
+func (session *Session) Positions(accountNumber string, filterOpts ...PositionFilterOpts) ([]*Position, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req := client.R()
+
+	// set parameters from filterOpts
+	var filter PositionFilterOpts
+	if len(filterOpts) > 0 {
+		filter = filterOpts[0]
+
+		if len(filter.UnderlyingSymbol) > 0 {
+			req = req.SetQueryParamsFromValues(url.Values{
+				"underlying-symbol[]": filter.UnderlyingSymbol,
+			})
+		}
+
+		if filter.Symbol != "" {
+			req = req.SetQueryParam("symbol", filter.Symbol)
+		}
+
+		if filter.InstrumentType != UndefinedInstrument {
+			req = req.SetQueryParam("instrument-type", filter.InstrumentType.String())
+		}
+
+		if filter.IncludeClosedPositions || filter.ClosedWithin > 0 {
+			req = req.SetQueryParam("include-closed-positions", "true")
+		}
+
+		if filter.UnderlyingProductCode != "" {
+			req = req.SetQueryParam("underlying-product-code", filter.UnderlyingProductCode)
+		}
+
+		if len(filter.PartitionKeys) > 0 {
+			req = req.SetQueryParamsFromValues(url.Values{
+				"partition-keys[]": filter.PartitionKeys,
+			})
+		}
+
+		if filter.NetPositions {
+			req = req.SetQueryParam("net-positions", "true")
+		}
+
+		if filter.IncludeMarks {
+			req = req.SetQueryParam("include-marks", "true")
+		}
+
+	}
+
+	positions, _, err := fetchItems(req, fmt.Sprintf("/accounts/%s/positions", accountNumber), "positions", parsePosition)
+	if err != nil {
+		return nil, err
+	}
+
+	// the API has no lookback window for closed positions so filter them client-side
+	if filter.ClosedWithin > 0 && !filter.IncludeClosedPositions {
+		cutoff := time.Now().Add(-filter.ClosedWithin)
+		filtered := make([]*Position, 0, len(positions))
+		for _, position := range positions {
+			if position.Quantity == 0 && position.UpdatedAt.Before(cutoff) {
+				continue
+			}
+			filtered = append(filtered, position)
+		}
+		positions = filtered
+	}
+
+	return positions, nil
+}
+
+// LastPrice returns the most recent trade price for symbol using the
+// market-data snapshot endpoint. If the symbol has not traded the mark price
+// is returned instead.
+func (session *Session) LastPrice(symbol string, instrumentType InstrumentTypeChoice) (float64, error) {
+	snapshots, err := session.MarketDataMixed(map[string]InstrumentTypeChoice{symbol: instrumentType})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.Symbol != symbol {
+			continue
+		}
+
+		if snapshot.Last != 0 {
+			return snapshot.Last, nil
+		}
+
+		if snapshot.Mark != 0 {
+			return snapshot.Mark, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrNoPriceAvailable, symbol)
+}
+
+// MarketDataMixed returns a market data snapshot for each symbol. Symbols of
+// different instrument types are grouped into as few requests as possible.
+func (session *Session) MarketDataMixed(symbolsWithTypes map[string]InstrumentTypeChoice) ([]*MarketDataSnapshot, error) {
+	symbols := make([]string, 0, len(symbolsWithTypes))
+	for symbol, instrumentType := range symbolsWithTypes {
+		if instrumentType.MarketDataParamKey() == "" {
+			return nil, fmt.Errorf("%w: %s (%s)", ErrUnsupportedInstrumentType, instrumentType, symbol)
+		}
+		symbols = append(symbols, symbol)
+	}
+
+	// sort symbols so that requests are deterministic
+	sort.Strings(symbols)
+
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]*MarketDataSnapshot, 0, len(symbols))
+	for start := 0; start < len(symbols); start += maxMarketDataSymbols {
+		end := min(start+maxMarketDataSymbols, len(symbols))
+
+		params := url.Values{}
+		for _, symbol := range symbols[start:end] {
+			params.Add(symbolsWithTypes[symbol].MarketDataParamKey(), symbol)
+		}
+
+		resp, err := client.R().
+			SetQueryParamsFromValues(params).
+			Get("/market-data/by-type")
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode() >= 400 {
+			return nil, fmt.Errorf("%w %s (market-data): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+		}
+
+		for _, item := range gjson.Get(string(resp.Body()), "data.items").Array() {
+			snapshots = append(snapshots, &MarketDataSnapshot{
+				Symbol:         item.Get("symbol").String(),
+				InstrumentType: InstrumentTypeFromString(item.Get("instrument-type").String()),
+				UpdatedAt:      item.Get("updated-at").Time(),
+				Bid:            item.Get("bid").Float(),
+				BidSize:        item.Get("bid-size").Float(),
+				Ask:            item.Get("ask").Float(),
+				AskSize:        item.Get("ask-size").Float(),
+				Mid:            item.Get("mid").Float(),
+				Mark:           item.Get("mark").Float(),
+				Last:           item.Get("last").Float(),
+				Open:           item.Get("open").Float(),
+				DayHighPrice:   item.Get("day-high-price").Float(),
+				DayLowPrice:    item.Get("day-low-price").Float(),
+				Close:          item.Get("close").Float(),
+				PrevClose:      item.Get("prev-close").Float(),
+				Volume:         item.Get("volume").Float(),
+			})
+		}
+	}
+
+	return snapshots, nil
+}
+
+// OptionChain returns every equity option contract for the underlying symbol
+func (session *Session) OptionChain(symbol string) ([]*OptionInstrument, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.R().Get(fmt.Sprintf("/option-chains/%s", url.PathEscape(symbol)))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return nil, fmt.Errorf("%w %s (option-chains): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	arr := gjson.Get(string(resp.Body()), "data.items").Array()
+	chain := make([]*OptionInstrument, len(arr))
+	for idx, option := range arr {
+		chain[idx] = parseOptionInstrument(option)
+	}
+
+	return chain, nil
+}
+
+// PositionsByUnderlying returns the account's positions grouped by their
+// underlying symbol
+func (session *Session) PositionsByUnderlying(accountNumber string, filter PositionFilterOpts) (map[string][]*Position, error) {
+	positions, err := session.Positions(accountNumber, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	grouped := make(map[string][]*Position)
+	for _, position := range positions {
+		grouped[position.UnderlyingSymbol] = append(grouped[position.UnderlyingSymbol], position)
+	}
+
+	return grouped, nil
+}
+
+// DeltaByExpiration sums the delta exposure (delta * signed quantity *
+// multiplier) of the account's option positions by expiration date. greeks
+// maps each option symbol to its per-contract delta, e.g. from market data.
+func (session *Session) DeltaByExpiration(accountNumber string, greeks map[string]float64) (map[time.Time]float64, error) {
+	positions, err := session.Positions(accountNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	exposure := make(map[time.Time]float64)
+	for _, position := range positions {
+		instrumentType := InstrumentTypeFromString(position.InstrumentType)
+		if instrumentType != EquityOption && instrumentType != FutureOption {
+			continue
+		}
+
+		delta, ok := greeks[position.Symbol]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNoGreeksAvailable, position.Symbol)
+		}
+
+		quantity := position.Quantity
+		if position.QuantityDirection == "Short" {
+			quantity = -quantity
+		}
+
+		expiresAt := position.ExpiresAt
+		expiration := time.Date(expiresAt.Year(), expiresAt.Month(), expiresAt.Day(), 0, 0, 0, 0, time.UTC)
+		exposure[expiration] += delta * quantity * position.Multiplier
+	}
+
+	return exposure, nil
+}
+
+// ResolveInstrumentTypes determines the instrument type of each symbol.
+// Futures, future options, cryptocurrencies quoted in USD (e.g. BTC/USD), and
+// OCC option symbols are recognized by their format; the remaining symbols,
+// including share classes such as BRK/B, are looked up with the equities
+// instrument endpoint. Symbols that cannot be resolved map to
+// UndefinedInstrument.
+func (session *Session) ResolveInstrumentTypes(symbols ...string) (map[string]InstrumentTypeChoice, error) {
+	resolved := make(map[string]InstrumentTypeChoice, len(symbols))
+	equities := make([]string, 0, len(symbols))
+
+	for _, symbol := range symbols {
+		switch {
+		case strings.HasPrefix(symbol, "./"):
+			resolved[symbol] = FutureOption
+		case strings.HasPrefix(symbol, "/"):
+			resolved[symbol] = Future
+		case occSymbolRegex.MatchString(symbol):
+			resolved[symbol] = EquityOption
+		case strings.HasSuffix(symbol, "/USD"):
+			resolved[symbol] = Cryptocurrency
+		default:
+			resolved[symbol] = UndefinedInstrument
+			equities = append(equities, symbol)
+		}
+	}
+
+	if len(equities) == 0 {
+		return resolved, nil
+	}
+
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.R().
+		SetQueryParamsFromValues(url.Values{
+			"symbol[]": equities,
+		}).
+		Get("/instruments/equities")
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return nil, fmt.Errorf("%w %s (instruments/equities): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	for _, equity := range gjson.Get(string(resp.Body()), "data.items").Array() {
+		symbol := equity.Get("symbol").String()
+		if _, ok := resolved[symbol]; ok {
+			resolved[symbol] = Equity
+		}
+	}
+
+	return resolved, nil
+}
+
+// Multiplier returns the contract multiplier for symbol, e.g. 100 for standard
+// equity options. Multipliers are fetched from the instruments API once and
+// then cached for the life of the process.
+func (session *Session) Multiplier(symbol string, instrumentType InstrumentTypeChoice) (float64, error) {
+	var path, field string
+
+	switch instrumentType {
+	case Equity, Cryptocurrency:
+		return 1, nil
+	case EquityOption:
+		path, field = "/instruments/equity-options/%s", "data.shares-per-contract"
+	case Future:
+		path, field = "/instruments/futures/%s", "data.notional-multiplier"
+	case FutureOption:
+		path, field = "/instruments/future-options/%s", "data.multiplier"
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedInstrumentType, instrumentType)
+	}
+
+	cacheKey := instrumentType.String() + ":" + symbol
+	if multiplier, ok := multiplierCache.Load(cacheKey); ok {
+		return multiplier.(float64), nil
+	}
+
+	client, err := session.restyClient()
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := client.R().Get(fmt.Sprintf(path, url.PathEscape(symbol)))
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return 0, fmt.Errorf("%w %s (instruments): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	multiplier := gjson.Get(string(resp.Body()), field).Float()
+	if multiplier == 0 {
+		return 0, fmt.Errorf("%w: no multiplier for %s", ErrInvalidHTTPResponse, symbol)
+	}
+
+	multiplierCache.Store(cacheKey, multiplier)
+
+	return multiplier, nil
+}
+
+// OrderCapabilities returns the order types and time-in-force values allowed
+// when trading symbol. The instrument is looked up to determine whether it is
+// active or restricted to closing orders; the allowed order types and
+// time-in-force values are determined by the instrument type.
+func (session *Session) OrderCapabilities(symbol string, instrumentType InstrumentTypeChoice) (*OrderCapabilities, error) {
+	var path string
+	capabilities := &OrderCapabilities{
+		Symbol:         symbol,
+		InstrumentType: instrumentType,
+	}
+
+	switch instrumentType {
+	case Equity:
+		path = "/instruments/equities/%s"
+		capabilities.OrderTypes = []OrderTypeChoice{Limit, Market, MarketableLimit, Stop, StopLimit, NotionalMarket}
+		capabilities.TimeInForces = []TimeInForceChoice{Day, GTC, GTD, Ext, GTCExt, IOC}
+	case EquityOption:
+		path = "/instruments/equity-options/%s"
+		capabilities.OrderTypes = []OrderTypeChoice{Limit, Market, MarketableLimit, Stop, StopLimit}
+		capabilities.TimeInForces = []TimeInForceChoice{Day, GTC, GTD}
+	case Future:
+		path = "/instruments/futures/%s"
+		capabilities.OrderTypes = []OrderTypeChoice{Limit, Market, Stop, StopLimit}
+		capabilities.TimeInForces = []TimeInForceChoice{Day, GTC, GTD}
+	case FutureOption:
+		path = "/instruments/future-options/%s"
+		capabilities.OrderTypes = []OrderTypeChoice{Limit, Market, Stop, StopLimit}
+		capabilities.TimeInForces = []TimeInForceChoice{Day, GTC, GTD}
+	case Cryptocurrency:
+		path = "/instruments/cryptocurrencies/%s"
+		capabilities.OrderTypes = []OrderTypeChoice{Limit, Market, StopLimit, NotionalMarket}
+		capabilities.TimeInForces = []TimeInForceChoice{GTC, IOC}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedInstrumentType, instrumentType)
+	}
+
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.R().Get(fmt.Sprintf(path, url.PathEscape(symbol)))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return nil, fmt.Errorf("%w %s (instruments): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	instrument := gjson.Get(string(resp.Body()), "data")
+	capabilities.Active = instrument.Get("active").Bool()
+	capabilities.IsClosingOnly = instrument.Get("is-closing-only").Bool()
+
+	return capabilities, nil
+}
+
+// FutureStreamerSymbol returns the symbol used by the market data streamer for
+// the futures contract tradingSymbol, e.g. `/ESZ9` is streamed as `/ESZ19:XCME`.
+// Results are cached for the life of the process.
+func (session *Session) FutureStreamerSymbol(tradingSymbol string) (string, error) {
+	if streamerSymbol, ok := streamerSymbolCache.Load(tradingSymbol); ok {
+		return streamerSymbol.(string), nil
+	}
+
+	client, err := session.restyClient()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.R().Get(fmt.Sprintf("/instruments/futures/%s", url.PathEscape(tradingSymbol)))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return "", fmt.Errorf("%w %s (instruments/futures): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	streamerSymbol := gjson.Get(string(resp.Body()), "data.streamer-symbol").String()
+	if streamerSymbol == "" {
+		return "", fmt.Errorf("%w: no streamer symbol for %s", ErrInvalidHTTPResponse, tradingSymbol)
+	}
+
+	streamerSymbolCache.Store(tradingSymbol, streamerSymbol)
+
+	return streamerSymbol, nil
+}
+
+// Transactions returns a page of the accounts transactions and the pagination
+// details of the page
+func (session *Session) Transactions(accountNumber string, filterOpts ...TransactionFilterOpts) ([]*Transaction, *Pagination, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req := client.R()
+
+	// set parameters from filterOpts
+	if len(filterOpts) > 0 {
+		filter := filterOpts[0]
+
+		if err := filter.Validate(); err != nil {
+			return nil, nil, err
+		}
+
+		if filter.PerPage > 0 {
+			req = req.SetQueryParam("per-page", fmt.Sprint(filter.PerPage))
+		}
+
+		if filter.PageOffset > 0 {
+			req = req.SetQueryParam("page-offset", fmt.Sprint(filter.PageOffset))
+		}
+
+		if filter.Sort != nil {
+			req = req.SetQueryParam("sort", filter.Sort.String())
+		}
+
+		if len(filter.TransactionTypes) == 1 {
+			req = req.SetQueryParam("type", filter.TransactionTypes[0])
+		} else if len(filter.TransactionTypes) > 1 {
+			req = req.SetQueryParamsFromValues(url.Values{
+				"types[]": filter.TransactionTypes,
+			})
+		}
+
+		if len(filter.TransactionSubTypes) > 0 {
+			req = req.SetQueryParamsFromValues(url.Values{
+				"sub-type[]": filter.TransactionSubTypes,
+			})
+		}
+
+		if filter.StartDate.After(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			req = req.SetQueryParam("start-date", filter.StartDate.Format(time.RFC3339))
+		}
+
+		if filter.EndDate.After(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			req = req.SetQueryParam("end-date", filter.EndDate.Format(time.RFC3339))
+		}
+
+		if filter.Symbol != "" {
+			req = req.SetQueryParam("symbol", filter.Symbol)
+		}
+
+		if filter.InstrumentType != UndefinedInstrument {
+			req = req.SetQueryParam("instrument-type", filter.InstrumentType.String())
+		}
+
+		if filter.UnderlyingSymbol != "" {
+			req = req.SetQueryParam("underlying-symbol", filter.UnderlyingSymbol)
+		}
+
+		if filter.Action != UndefinedAction {
+			req = req.SetQueryParam("action", filter.Action.String())
+		}
+
+		if filter.PartitionKey != "" {
+			req = req.SetQueryParam("partition-key", filter.PartitionKey)
+		}
+
+		if filter.FuturesSymbol != "" {
+			req = req.SetQueryParam("futures-symbol", filter.FuturesSymbol)
+		}
+	}
+
+	transactions, pagination, err := fetchItems(req, fmt.Sprintf("/accounts/%s/transactions", accountNumber), "transactions", parseTransaction)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return transactions, pagination, nil
+}
+
+// AllTransactions returns every transaction matching filter by requesting
+// each page of results in turn, starting at filter.PageOffset
+func (session *Session) AllTransactions(accountNumber string, filter TransactionFilterOpts) ([]*Transaction, error) {
+	transactions := make([]*Transaction, 0)
+	err := session.ForEachTransaction(context.Background(), accountNumber, filter, func(trx *Transaction) error {
+		transactions = append(transactions, trx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// ForEachTransaction calls fn for every transaction matching filter,
+// requesting one page at a time starting at filter.PageOffset. Iteration stops
+// when all pages have been read, when ctx is done, or when fn returns an
+// error; in the latter cases the error is returned.
+func (session *Session) ForEachTransaction(ctx context.Context, accountNumber string, filter TransactionFilterOpts, fn func(*Transaction) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		transactions, pagination, err := session.Transactions(accountNumber, filter)
+		if err != nil {
+			return err
+		}
+
+		for _, trx := range transactions {
+			if err := fn(trx); err != nil {
+				return err
+			}
+		}
+
+		if len(transactions) == 0 || pagination == nil || pagination.CurrentItemCount == 0 ||
+			(pagination.TotalPages > 0 && filter.PageOffset+1 >= pagination.TotalPages) {
+			return nil
+		}
+
+		filter.PageOffset++
+	}
+}
+
+// TransactionsForOrder returns the account's transactions (fills, fees, etc.)
+// that belong to orderID. The API cannot filter by order id so every page of
+// transactions is searched client-side; use filterOpts to narrow the date
+// range that is searched.
+func (session *Session) TransactionsForOrder(accountNumber string, orderID int64, filterOpts ...TransactionFilterOpts) ([]*Transaction, error) {
+	var filter TransactionFilterOpts
+	if len(filterOpts) > 0 {
+		filter = filterOpts[0]
+	}
+
+	matched := make([]*Transaction, 0)
+	err := session.ForEachTransaction(context.Background(), accountNumber, filter, func(trx *Transaction) error {
+		if trx.OrderID == orderID {
+			matched = append(matched, trx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return matched, nil
+}
+
+// Transfers returns the account's funding events (ACH, wire, etc.) executed
+// between start and end. The API does not have a dedicated transfers
+// endpoint so transfers are read from the "Money Movement" transactions.
+func (session *Session) Transfers(accountNumber string, start, end time.Time) ([]*Transfer, error) {
+	transactions, err := session.AllTransactions(accountNumber, TransactionFilterOpts{
+		StartDate:        start,
+		EndDate:          end,
+		TransactionTypes: []string{TransactionTypeMoneyMovement},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := make([]*Transfer, 0, len(transactions))
+	for _, trx := range transactions {
+		if trx.TransactionType != TransactionTypeMoneyMovement || trx.ExecutedAt.Before(start) || trx.ExecutedAt.After(end) {
+			continue
+		}
+
+		transfers = append(transfers, &Transfer{
+			ID:              trx.ID,
+			AccountNumber:   trx.AccountNumber,
+			TransferType:    trx.TransactionSubType,
+			Description:     trx.Description,
+			Amount:          trx.NetValue,
+			AmountEffect:    trx.NetValueEffect,
+			ExecutedAt:      trx.ExecutedAt,
+			TransactionDate: trx.TransactionDate,
+		})
+	}
+
+	return transfers, nil
+}
+
+// CommissionSummary totals the commissions and fees on the account's
+// transactions executed between start and end, broken down by instrument
+// type
+func (session *Session) CommissionSummary(accountNumber string, start, end time.Time) (*CommissionSummary, error) {
+	transactions, err := session.AllTransactions(accountNumber, TransactionFilterOpts{
+		StartDate: start,
+		EndDate:   end,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &CommissionSummary{
+		AccountNumber:    accountNumber,
+		Start:            start,
+		End:              end,
+		ByInstrumentType: make(map[InstrumentTypeChoice]*FeeTotals),
+	}
+
+	for _, trx := range transactions {
+		if trx.ExecutedAt.Before(start) || trx.ExecutedAt.After(end) {
+			continue
+		}
+
+		byType, ok := summary.ByInstrumentType[trx.InstrumentType]
+		if !ok {
+			byType = &FeeTotals{}
+			summary.ByInstrumentType[trx.InstrumentType] = byType
+		}
+
+		byType.add(trx)
+		summary.Totals.add(trx)
+	}
+
+	return summary, nil
+}
+
+// ActivityTimeline merges the account's orders, order fills, and
+// transactions that occurred after since into a single feed sorted from
+// oldest to newest. Orders are placed on the timeline when they were
+// received.
+func (session *Session) ActivityTimeline(accountNumber string, since time.Time) ([]TimelineEvent, error) {
+	orders, err := session.allOrders(accountNumber, OrdersFilterOpts{StartDate: since})
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := session.AllTransactions(accountNumber, TransactionFilterOpts{StartDate: since})
+	if err != nil {
+		return nil, err
+	}
+
+	timeline := make([]TimelineEvent, 0, len(orders)+len(transactions))
+	for _, order := range orders {
+		if order.ReceivedAt.After(since) {
+			timeline = append(timeline, TimelineEvent{
+				Type:          OrderTimelineEvent,
+				Time:          order.ReceivedAt,
+				AccountNumber: order.AccountNumber,
+				Symbol:        order.UnderlyingSymbol,
+				Order:         order,
+			})
+		}
+
+		for _, leg := range order.Legs {
+			for _, fill := range leg.Fills {
+				if !fill.FilledAt.After(since) {
+					continue
+				}
+
+				timeline = append(timeline, TimelineEvent{
+					Type:          FillTimelineEvent,
+					Time:          fill.FilledAt,
+					AccountNumber: order.AccountNumber,
+					Symbol:        leg.Symbol,
+					Order:         order,
+					Fill:          fill,
+				})
+			}
+		}
+	}
+
+	for _, trx := range transactions {
+		if !trx.ExecutedAt.After(since) {
+			continue
+		}
+
+		timeline = append(timeline, TimelineEvent{
+			Type:          TransactionTimelineEvent,
+			Time:          trx.ExecutedAt,
+			AccountNumber: trx.AccountNumber,
+			Symbol:        trx.Symbol,
+			Transaction:   trx,
+		})
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Time.Before(timeline[j].Time)
+	})
+
+	return timeline, nil
+}
+
+// Orders returns a page of the accounts's orders and the pagination details
+// of the page
+func (session *Session) Orders(accountNumber string, filterOpts ...OrdersFilterOpts) ([]*OrderStatus, *Pagination, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req := client.R()
+
+	// set parameters from filterOpts
+	if len(filterOpts) > 0 {
+		filter := filterOpts[0]
+
+		if filter.PerPage > 0 {
+			req = req.SetQueryParam("per-page", fmt.Sprint(filter.PerPage))
+		}
+
+		if filter.PageOffset > 0 {
+			req = req.SetQueryParam("page-offset", fmt.Sprint(filter.PageOffset))
+		}
+
+		if filter.Sort != nil {
+			req = req.SetQueryParam("sort", filter.Sort.String())
+		}
+
+		if len(filter.Status) > 0 {
+			req = req.SetQueryParamsFromValues(url.Values{
+				"status[]": filter.Status,
+			})
+		}
+
+		if filter.StartDate.After(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			req = req.SetQueryParam("start-date", filter.StartDate.Format(time.RFC3339))
+		}
+
+		if filter.EndDate.After(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			req = req.SetQueryParam("end-date", filter.EndDate.Format(time.RFC3339))
+		}
+
+		if filter.UnderlyingSymbol != "" {
+			req = req.SetQueryParam("underlying-symbol", filter.UnderlyingSymbol)
+		}
+
+		if filter.UnderlyingInstrumentType != UndefinedInstrument {
+			req = req.SetQueryParam("underlying-instrument-type", filter.UnderlyingInstrumentType.String())
+		}
+
+		if filter.FuturesSymbol != "" {
+			req = req.SetQueryParam("futures-symbol", filter.FuturesSymbol)
+		}
+	}
+
+	orders, pagination, err := fetchItems(req, fmt.Sprintf("/accounts/%s/orders", accountNumber), "orders", parseOrderStatus)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return orders, pagination, nil
+}
+
+// allOrders returns the orders matching filter from every page of results,
+// starting at filter.PageOffset
+func (session *Session) allOrders(accountNumber string, filter OrdersFilterOpts) ([]*OrderStatus, error) {
+	orders := make([]*OrderStatus, 0)
+	for {
+		page, pagination, err := session.Orders(accountNumber, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, page...)
+
+		if len(page) == 0 || pagination == nil || pagination.CurrentItemCount == 0 ||
+			(pagination.TotalPages > 0 && filter.PageOffset+1 >= pagination.TotalPages) {
+			return orders, nil
+		}
+
+		filter.PageOffset++
+	}
+}
+
+// HasLiveOrders returns true if the account has any working orders. Only a
+// single order is requested so this is inexpensive enough to poll.
+func (session *Session) HasLiveOrders(accountNumber string) (bool, error) {
+	client, err := session.restyClient()
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := client.R().
+		SetQueryParam("per-page", "1").
+		SetQueryParamsFromValues(url.Values{
+			"status[]": {"Received", "Routed", "In Flight", "Live", "Cancel Requested", "Replace Requested", "Contingent"},
+		}).
+		Get(fmt.Sprintf("/accounts/%s/orders", accountNumber))
+	if err != nil {
+		return false, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return false, fmt.Errorf("%w %s (live orders): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	body := string(resp.Body())
+	if total := gjson.Get(body, "pagination.total-items"); total.Exists() {
+		return total.Int() > 0, nil
+	}
+
+	return len(gjson.Get(body, "data.items").Array()) > 0, nil
+}
+
+// SubmitOrder sends the specified order to tastytrade for execution
+func (session *Session) SubmitOrder(accountNumber string, order *Order) (orderResponse *OrderResponse, err error) {
+	defer func() {
+		event := &OrderEvent{Action: OrderSubmitted, AccountNumber: accountNumber, Order: order, Err: err}
+		if orderResponse != nil {
+			event.OrderID = orderResponse.OrderID()
+			event.Status = orderResponse.Order
+			event.Errors = orderResponse.Errors
+			event.Warnings = orderResponse.Warnings
+		}
+		session.auditOrder(event)
+	}()
+
+	if err := order.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.R().
+		SetBody(order).
+		Post(fmt.Sprintf("/accounts/%s/orders", accountNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return nil, fmt.Errorf("%w %s: %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	orderResponse = parseOrderResponse(gjson.Get(string(resp.Body()), "data"))
+
+	if rejected, reason := orderResponse.IsRejected(); rejected {
+		log.Warn().Str("OrderID", orderResponse.OrderID()).Str("RejectReason", reason).Msg("order was rejected")
+	}
+
+	return orderResponse, nil
+}
+
+// ReplaceOrder replaces the working order orderID with order, e.g. to reprice
+// a resting limit order without losing its place in the queue. The status in
+// the response is the new order; its ReplacesOrderID is set to orderID.
+func (session *Session) ReplaceOrder(accountNumber, orderID string, order *Order) (orderResponse *OrderResponse, err error) {
+	defer func() {
+		event := &OrderEvent{Action: OrderReplaced, AccountNumber: accountNumber, OrderID: orderID, Order: order, Err: err}
+		if orderResponse != nil {
+			event.Status = orderResponse.Order
+			event.Errors = orderResponse.Errors
+			event.Warnings = orderResponse.Warnings
+		}
+		session.auditOrder(event)
+	}()
+
+	if err := order.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.R().
+		SetBody(order).
+		Put(fmt.Sprintf("/accounts/%s/orders/%s", accountNumber, orderID))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return nil, fmt.Errorf("%w %s (replace order): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	data := gjson.Get(string(resp.Body()), "data")
+	if data.Get("order").Exists() {
+		orderResponse = parseOrderResponse(data)
+	} else {
+		// the replacement order may be returned directly in data
+		orderResponse = &OrderResponse{Order: parseOrderStatus(data)}
+	}
+
+	if orderResponse.Order.ReplacesOrderID == "" {
+		orderResponse.Order.ReplacesOrderID = orderID
+	}
+
+	return orderResponse, nil
+}
+
+// DryRunOrder validates order and calculates its effect on buying power and
+// its fees without routing it for execution. Errors and warnings that would
+// be returned when submitting the order are included in the response. The
+// returned order status does not have an ID.
+func (session *Session) DryRunOrder(accountNumber string, order *Order) (*OrderResponse, error) {
+	if err := order.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.R().
+		SetBody(order).
+		Post(fmt.Sprintf("/accounts/%s/orders/dry-run", accountNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() >= 400 {
+		return nil, fmt.Errorf("%w %s (dry-run): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	}
+
+	return parseOrderResponse(gjson.Get(string(resp.Body()), "data")), nil
+}
+
+// DryRunMargin calculates the combined effect of orders on the margin
+// requirement and buying power of accountNumber. tastytrade does not offer a
+// what-if margin calculation for a group of orders so each order is dry-run
+// separately and the effects are summed; orders that would offset each other,
+// e.g. a hedge, are not netted.
+func (session *Session) DryRunMargin(accountNumber string, orders []*Order) (*MarginRequirement, error) {
+	if len(orders) == 0 {
+		return nil, fmt.Errorf("%w: no orders to dry-run", ErrInvalidOrder)
+	}
+
+	// debits consume margin and buying power, credits release them
+	signed := func(amount float64, effect Effect) float64 {
+		if effect == Credit {
+			return -amount
+		}
+		return amount
+	}
+
+	requirement := &MarginRequirement{
+		Orders: make([]*OrderResponse, 0, len(orders)),
+	}
+
+	for idx, order := range orders {
+		orderResponse, err := session.DryRunOrder(accountNumber, order)
+		if err != nil {
+			return nil, fmt.Errorf("order %d: %w", idx, err)
+		}
+
+		requirement.Orders = append(requirement.Orders, orderResponse)
+
+		if effect := orderResponse.EffectOnBuyingPower; effect != nil {
+			if idx == 0 {
+				requirement.CurrentBuyingPower = effect.CurrentBuyingPower
+			}
+			requirement.ChangeInMarginRequirement += signed(effect.ChangeInMarginRequirement, effect.ChangeInMarginRequirementEffect)
+			requirement.ChangeInBuyingPower -= signed(effect.ChangeInBuyingPower, effect.ChangeInBuyingPowerEffect)
+		}
 
-	if resp.StatusCode() >= 400 {
-		return nil, fmt.Errorf("%w %s (balances): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+		if fees := orderResponse.FeeCalculation; fees != nil {
+			requirement.TotalFees += signed(fees.TotalFees, fees.TotalFeesEffect)
+		}
 	}
 
-	body := string(resp.Body())
+	requirement.NewBuyingPower = requirement.CurrentBuyingPower + requirement.ChangeInBuyingPower
 
-	balance := &Balance{
-		AccountNumber:                      gjson.Get(body, "data.account-number").String(),
-		CashBalance:                        gjson.Get(body, "data.cash-balance").Float(),
-		LongEquityValue:                    gjson.Get(body, "data.long-equity-value").Float(),
-		ShortEquityValue:                   gjson.Get(body, "data.short-equity-value").Float(),
-		LongDerivativeValue:                gjson.Get(body, "data.long-derivative-value").Float(),
-		ShortDerivativeValue:               gjson.Get(body, "data.short-derivative-value").Float(),
-		LongFuturesValue:                   gjson.Get(body, "data.long-futures-value").Float(),
-		ShortFuturesValue:                  gjson.Get(body, "data.short-futures-value").Float(),
-		LongFuturesDerivativeValue:         gjson.Get(body, "data.long-futures-derivative-value").Float(),
-		ShortFuturesDerivativeValue:        gjson.Get(body, "data.short-futures-derivative-value").Float(),
-		LongMargineableValue:               gjson.Get(body, "data.long-margineable-value").Float(),
-		ShortMargineableValue:              gjson.Get(body, "data.short-margineable-value").Float(),
-		MarginEquity:                       gjson.Get(body, "data.margin-equity").Float(),
-		EquityBuyingPower:                  gjson.Get(body, "data.equity-buying-power").Float(),
-		DerivativeBuyingPower:              gjson.Get(body, "data.derivative-buying-power").Float(),
-		DayTradingBuyingPower:              gjson.Get(body, "data.day-trading-buying-power").Float(),
-		FuturesMarginRequirement:           gjson.Get(body, "data.futures-margin-requirement").Float(),
-		AvailableTradingFunds:              gjson.Get(body, "data.available-trading-funds").Float(),
-		MaintenanceRequirement:             gjson.Get(body, "data.maintenance-requirement").Float(),
-		MaintenanceCallValue:               gjson.Get(body, "data.maintenance-call-value").Float(),
-		RegTCallValue:                      gjson.Get(body, "data.reg-t-call-value").Float(),
-		DayTradingCallValue:                gjson.Get(body, "data.day-trading-call-value").Float(),
-		DayEquityCallValue:                 gjson.Get(body, "data.day-equity-call-value").Float(),
-		NetLiquidatingValue:                gjson.Get(body, "data.net-liquidating-value").Float(),
-		CashAvailableToWithdraw:            gjson.Get(body, "data.cash-available-to-withdraw").Float(),
-		DayTradeExcess:                     gjson.Get(body, "data.day-trade-excess").Float(),
-		PendingCash:                        gjson.Get(body, "data.pending-cash").Float(),
-		PendingCashEffect:                  gjson.Get(body, "data.pending-cash-effect").String(),
-		LongCryptocurrencyValue:            gjson.Get(body, "data.long-cryptocurrency-value").Float(),
-		ShortCryptocurrencyValue:           gjson.Get(body, "data.short-cryptocurrency-value").Float(),
-		CryptocurrencyMarginRequirement:    gjson.Get(body, "data.cryptocurrency-margin-requirement").Float(),
-		UnsettledCryptocurrencyFiatAmount:  gjson.Get(body, "data.unsettled-cryptocurrency-fiat-amount").Float(),
-		UnsettledCryptocurrencyFiatEffect:  gjson.Get(body, "data.unsettled-cryptocurrency-fiat-effect").String(),
-		ClosedLoopAvailableBalance:         gjson.Get(body, "data.closed-loop-available-balance").Float(),
-		EquityOfferingMarginRequirement:    gjson.Get(body, "data.equity-offering-margin-requirement").Float(),
-		LongBondValue:                      gjson.Get(body, "data.long-bond-value").Float(),
-		BondMarginRequirement:              gjson.Get(body, "data.bond-margin-requirement").Float(),
-		UsedDerivativeBuyingPower:          gjson.Get(body, "data.used-derivative-buying-power").Float(),
-		SnapshotDate:                       gjson.Get(body, "data.snapshot-date").Time(),
-		RegTMarginRequirement:              gjson.Get(body, "data.reg-t-margin-requirement").Float(),
-		FuturesOvernightMarginRequirement:  gjson.Get(body, "data.futures-overnight-margin-requirement").Float(),
-		FuturesIntradayMarginRequirement:   gjson.Get(body, "data.futures-intraday-margin-requirement").Float(),
-		MaintenanceExcess:                  gjson.Get(body, "data.maintenance-excess").Float(),
-		PendingMarginInterest:              gjson.Get(body, "data.pending-margin-interest").Float(),
-		EffectiveCryptocurrencyBuyingPower: gjson.Get(body, "data.effective-cryptocurrency-buying-power").Float(),
-		UpdatedAt:                          gjson.Get(body, "data.updated-at").Time(),
-	}
-
-	return balance, nil
+	return requirement, nil
 }
 
-// BalanceSnapshot returns a snapshot of the account balance at the specified time
-func (session *Session) BalanceSnapshot(accountNumber string, timeOfDay TimeOfDay, snapshotDate time.Time) (*Balance, error) {
+// GetOrder returns the current status of orderID
+func (session *Session) GetOrder(accountNumber string, orderID string) (*OrderStatus, error) {
 	client, err := session.restyClient()
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := client.R().
-		SetQueryParam("snapshot-date", snapshotDate.Format(time.RFC3339)).
-		SetQueryParam("time-of_day", timeOfDay.String()).
-		Get(fmt.Sprintf("/accounts/%s/balance-snapshots", accountNumber))
+	resp, err := client.R().Get(fmt.Sprintf("/accounts/%s/orders/%s", accountNumber, orderID))
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode() >= 400 {
-		return nil, fmt.Errorf("%w %s (balance-snapshots): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+		return nil, fmt.Errorf("%w %s (order): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
 	}
 
-	body := string(resp.Body())
-
-	balance := &Balance{
-		AccountNumber:                      gjson.Get(body, "data.account-number").String(),
-		CashBalance:                        gjson.Get(body, "data.cash-balance").Float(),
-		LongEquityValue:                    gjson.Get(body, "data.long-equity-value").Float(),
-		ShortEquityValue:                   gjson.Get(body, "data.short-equity-value").Float(),
-		LongDerivativeValue:                gjson.Get(body, "data.long-derivative-value").Float(),
-		ShortDerivativeValue:               gjson.Get(body, "data.short-derivative-value").Float(),
-		LongFuturesValue:                   gjson.Get(body, "data.long-futures-value").Float(),
-		ShortFuturesValue:                  gjson.Get(body, "data.short-futures-value").Float(),
-		LongFuturesDerivativeValue:         gjson.Get(body, "data.long-futures-derivative-value").Float(),
-		ShortFuturesDerivativeValue:        gjson.Get(body, "data.short-futures-derivative-value").Float(),
-		LongMargineableValue:               gjson.Get(body, "data.long-margineable-value").Float(),
-		ShortMargineableValue:              gjson.Get(body, "data.short-margineable-value").Float(),
-		MarginEquity:                       gjson.Get(body, "data.margin-equity").Float(),
-		EquityBuyingPower:                  gjson.Get(body, "data.equity-buying-power").Float(),
-		DerivativeBuyingPower:              gjson.Get(body, "data.derivative-buying-power").Float(),
-		DayTradingBuyingPower:              gjson.Get(body, "data.day-trading-buying-power").Float(),
-		FuturesMarginRequirement:           gjson.Get(body, "data.futures-margin-requirement").Float(),
-		AvailableTradingFunds:              gjson.Get(body, "data.available-trading-funds").Float(),
-		MaintenanceRequirement:             gjson.Get(body, "data.maintenance-requirement").Float(),
-		MaintenanceCallValue:               gjson.Get(body, "data.maintenance-call-value").Float(),
-		RegTCallValue:                      gjson.Get(body, "data.reg-t-call-value").Float(),
-		DayTradingCallValue:                gjson.Get(body, "data.day-trading-call-value").Float(),
-		DayEquityCallValue:                 gjson.Get(body, "data.day-equity-call-value").Float(),
-		NetLiquidatingValue:                gjson.Get(body, "data.net-liquidating-value").Float(),
-		CashAvailableToWithdraw:            gjson.Get(body, "data.cash-available-to-withdraw").Float(),
-		DayTradeExcess:                     gjson.Get(body, "data.day-trade-excess").Float(),
-		PendingCash:                        gjson.Get(body, "data.pending-cash").Float(),
-		PendingCashEffect:                  gjson.Get(body, "data.pending-cash-effect").String(),
-		LongCryptocurrencyValue:            gjson.Get(body, "data.long-cryptocurrency-value").Float(),
-		ShortCryptocurrencyValue:           gjson.Get(body, "data.short-cryptocurrency-value").Float(),
-		CryptocurrencyMarginRequirement:    gjson.Get(body, "data.cryptocurrency-margin-requirement").Float(),
-		UnsettledCryptocurrencyFiatAmount:  gjson.Get(body, "data.unsettled-cryptocurrency-fiat-amount").Float(),
-		UnsettledCryptocurrencyFiatEffect:  gjson.Get(body, "data.unsettled-cryptocurrency-fiat-effect").String(),
-		ClosedLoopAvailableBalance:         gjson.Get(body, "data.closed-loop-available-balance").Float(),
-		EquityOfferingMarginRequirement:    gjson.Get(body, "data.equity-offering-margin-requirement").Float(),
-		LongBondValue:                      gjson.Get(body, "data.long-bond-value").Float(),
-		BondMarginRequirement:              gjson.Get(body, "data.bond-margin-requirement").Float(),
-		UsedDerivativeBuyingPower:          gjson.Get(body, "data.used-derivative-buying-power").Float(),
-		SnapshotDate:                       gjson.Get(body, "data.snapshot-date").Time(),
-		RegTMarginRequirement:              gjson.Get(body, "data.reg-t-margin-requirement").Float(),
-		FuturesOvernightMarginRequirement:  gjson.Get(body, "data.futures-overnight-margin-requirement").Float(),
-		FuturesIntradayMarginRequirement:   gjson.Get(body, "data.futures-intraday-margin-requirement").Float(),
-		MaintenanceExcess:                  gjson.Get(body, "data.maintenance-excess").Float(),
-		PendingMarginInterest:              gjson.Get(body, "data.pending-margin-interest").Float(),
-		EffectiveCryptocurrencyBuyingPower: gjson.Get(body, "data.effective-cryptocurrency-buying-power").Float(),
-		UpdatedAt:                          gjson.Get(body, "data.updated-at").Time(),
-	}
-
-	return balance, nil
+	return parseOrderStatus(gjson.Get(string(resp.Body()), "data")), nil
 }
 
-// Positions returns a list of the accounts positions
-func (session *Session) Positions(accountNumber string, filterOpts ...PositionFilterOpts) ([]*Position, error) {
-	client, err := session.restyClient()
-	if err != nil {
-		return nil, err
+// WaitForOrder polls orderID every pollInterval until it reaches a terminal
+// state (see OrderStatus.IsTerminal) and returns its final status. Working
+// states such as Received, Routed, In Flight, Live and Contingent are polled
+// through. If ctx is done first the most recently observed status is
+// returned along with ctx.Err().
+//
+// By default the first failed poll is returned as an error. Set
+// WaitForOrderOpts.MaxConsecutiveFailures to tolerate transient failures;
+// the poll interval is doubled after each consecutive failure up to
+// WaitForOrderOpts.MaxBackoff.
+func (session *Session) WaitForOrder(ctx context.Context, accountNumber, orderID string, pollInterval time.Duration, opts ...WaitForOrderOpts) (*OrderStatus, error) {
+	var opt WaitForOrderOpts
+	if len(opts) > 0 {
+		opt = opts[0]
 	}
 
-	req := client.R()
-
-	// set parameters from filterOpts
-	if len(filterOpts) > 1 {
-		filter := filterOpts[0]
-
-		if len(filter.UnderlyingSymbol) > 0 {
-			req = req.SetQueryParamsFromValues(url.Values{
-				"underlying-symbol[]": filter.UnderlyingSymbol,
-			})
-		}
-
-		if filter.Symbol != "" {
-			req = req.SetQueryParam("symbol", filter.Symbol)
-		}
+	if opt.MaxBackoff <= 0 {
+		opt.MaxBackoff = defaultMaxBackoff
+	}
+	opt.MaxBackoff = max(opt.MaxBackoff, pollInterval)
 
-		if filter.InstrumentType != UndefinedInstrument {
-			req = req.SetQueryParam("instrument-type", filter.InstrumentType.String())
-		}
+	var status *OrderStatus
+	failures := 0
 
-		if filter.IncludeClosedPositions {
-			req = req.SetQueryParam("include-closed-positions", "true")
-		}
+	for {
+		wait := pollInterval
 
-		if filter.UnderlyingProductCode != "" {
-			req = req.SetQueryParam("underlying-product-code", filter.UnderlyingProductCode)
-		}
+		current, err := session.GetOrder(accountNumber, orderID)
+		switch {
+		case err != nil:
+			failures++
+			if failures > opt.MaxConsecutiveFailures {
+				return status, err
+			}
 
-		if len(filter.PartitionKeys) > 0 {
-			req = req.SetQueryParamsFromValues(url.Values{
-				"partition-keys[]": filter.PartitionKeys,
-			})
+			log.Warn().Err(err).Str("OrderID", orderID).Int("Failures", failures).Msg("could not poll order status")
+			wait = backoff(pollInterval, failures, opt.MaxBackoff)
+		case current.IsTerminal():
+			return current, nil
+		default:
+			status = current
+			failures = 0
 		}
 
-		if filter.NetPositions {
-			req = req.SetQueryParam("net-positions", "true")
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(wait):
 		}
+	}
+}
 
-		if filter.IncludeMarks {
-			req = req.SetQueryParam("include-marks", "true")
+// backoff returns interval doubled for each failure, without exceeding
+// maxWait
+func backoff(interval time.Duration, failures int, maxWait time.Duration) time.Duration {
+	wait := interval
+	for idx := 0; idx < failures; idx++ {
+		if wait >= maxWait/2 {
+			return maxWait
 		}
-
+		wait *= 2
 	}
+	return min(wait, maxWait)
+}
 
-	resp, err := req.Get(fmt.Sprintf("/accounts/%s/positions", accountNumber))
+// SubmitAndWait submits order and then polls it every pollInterval until it
+// reaches a terminal state, returning the final status. If the order is not
+// accepted an error wrapping ErrOrderNotAccepted that includes the errors
+// returned by the API is returned.
+func (session *Session) SubmitAndWait(ctx context.Context, accountNumber string, order *Order, pollInterval time.Duration, opts ...WaitForOrderOpts) (*OrderStatus, error) {
+	resp, err := session.SubmitOrder(accountNumber, order)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode() >= 400 {
-		return nil, fmt.Errorf("%w %s (positions): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
-	}
-
-	arr := gjson.Get(string(resp.Body()), "data.items").Array()
-	positions := make([]*Position, len(arr))
-	for idx, pos := range arr {
-		positions[idx] = &Position{
-			AccountNumber:                 pos.Get("account-number").String(),
-			Symbol:                        pos.Get("symbol").String(),
-			InstrumentType:                pos.Get("instrument-type").String(),
-			UnderlyingSymbol:              pos.Get("underlying-symbol").String(),
-			Quantity:                      pos.Get("quantity").Float(),
-			QuantityDirection:             pos.Get("quantity-direction").String(),
-			ClosePrice:                    pos.Get("close-price").Float(),
-			AverageOpenPrice:              pos.Get("average-open-price").Float(),
-			AverageYearlyMarketClosePrice: pos.Get("average-yearly-market-close-price").Float(),
-			AverageDailyMarketClosePrice:  pos.Get("average-daily-market-close-price").Float(),
-			Multiplier:                    pos.Get("multiplier").Float(),
-			CostEffect:                    pos.Get("cost-effect").String(),
-			IsSuppressed:                  pos.Get("is-suppressed").Bool(),
-			IsFrozen:                      pos.Get("is-frozen").Bool(),
-			RestrictedQuantity:            pos.Get("restricted-quantity").Float(),
-			RealizedDayGain:               pos.Get("realized-day-gain").Float(),
-			RealizedDayGainEffect:         pos.Get("realized-day-gain-effect").String(),
-			RealizedDayGainDate:           pos.Get("realized-day-gain-date").Time(),
-			RealizedToday:                 pos.Get("realized-today").Float(),
-			RealizedTodayEffect:           pos.Get("realized-today-effect").String(),
-			RealizedTodayDate:             pos.Get("realized-today-date").Time(),
-			ExpiresAt:                     pos.Get("expires-at").Time(),
-			CreatedAt:                     pos.Get("created-at").Time(),
-			UpdatedAt:                     pos.Get("updated-at").Time(),
+	orderID := resp.OrderID()
+	if orderID == "" {
+		messages := make([]string, len(resp.Errors))
+		for idx, msg := range resp.Errors {
+			messages[idx] = fmt.Sprintf("%s: %s", msg.Code, msg.Message)
 		}
+		return nil, fmt.Errorf("%w: %s", ErrOrderNotAccepted, strings.Join(messages, "; "))
 	}
 
-	return positions, nil
+	return session.WaitForOrder(ctx, accountNumber, orderID, pollInterval, opts...)
 }
 
-// Transactions returns a list of the accounts transactions
-func (session *Session) Transactions(accountNumber string, filterOpts ...TransactionFilterOpts) ([]*Transaction, error) {
-	client, err := session.restyClient()
+// OrderReplacementChain returns every generation of orderID, from the
+// original order to the latest replacement, by following the
+// replaces-order-id and replacing-order-id links
+func (session *Session) OrderReplacementChain(accountNumber, orderID string) ([]*OrderStatus, error) {
+	current, err := session.GetOrder(accountNumber, orderID)
 	if err != nil {
 		return nil, err
 	}
 
-	req := client.R()
-
-	// set parameters from filterOpts
-	if len(filterOpts) > 1 {
-		filter := filterOpts[0]
+	visited := map[string]bool{current.ID: true}
 
-		if filter.PerPage > 0 {
-			req = req.SetQueryParam("per-page", fmt.Sprint(filter.PerPage))
+	// walk back to the original order
+	older := make([]*OrderStatus, 0)
+	for order := current; order.ReplacesOrderID != "" && !visited[order.ReplacesOrderID]; {
+		visited[order.ReplacesOrderID] = true
+		if order, err = session.GetOrder(accountNumber, order.ReplacesOrderID); err != nil {
+			return nil, err
 		}
+		older = append(older, order)
+	}
 
-		if filter.PageOffset > 0 {
-			req = req.SetQueryParam("page-offset", fmt.Sprint(filter.PageOffset))
+	chain := make([]*OrderStatus, 0, len(older)+1)
+	for idx := len(older) - 1; idx >= 0; idx-- {
+		chain = append(chain, older[idx])
+	}
+	chain = append(chain, current)
+
+	// walk forward to the latest replacement
+	for order := current; order.ReplacingOrderID != "" && !visited[order.ReplacingOrderID]; {
+		visited[order.ReplacingOrderID] = true
+		if order, err = session.GetOrder(accountNumber, order.ReplacingOrderID); err != nil {
+			return nil, err
 		}
+		chain = append(chain, order)
+	}
 
-		req = req.SetQueryParam("sort", filter.Sort.String())
+	return chain, nil
+}
 
-		if len(filter.TransactionTypes) == 1 {
-			req = req.SetQueryParam("type", filter.TransactionTypes[0])
-		} else if len(filter.TransactionTypes) > 1 {
-			req = req.SetQueryParamsFromValues(url.Values{
-				"types[]": filter.TransactionTypes,
-			})
-		}
+// DeleteOrder attempts to delete orderID. If `DeleteOrderOpts.IgnoreTerminal`
+// is set and the order has already reached a terminal state (e.g. it was
+// filled or cancelled) the current status is returned instead of an error.
+func (session *Session) DeleteOrder(accountNumber string, orderID string, opts ...DeleteOrderOpts) (orderStatus *OrderStatus, err error) {
+	defer func() {
+		session.auditOrder(&OrderEvent{Action: OrderCancelled, AccountNumber: accountNumber, OrderID: orderID, Status: orderStatus, Err: err})
+	}()
 
-		if len(filter.TransactionSubTypes) > 0 {
-			req = req.SetQueryParamsFromValues(url.Values{
-				"sub-type[]": filter.TransactionSubTypes,
-			})
-		}
+	var opt DeleteOrderOpts
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
 
-		if filter.StartDate.After(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)) {
-			req = req.SetQueryParam("start-date", filter.StartDate.Format(time.RFC3339))
-		}
+	client, err := session.restyClient()
+	if err != nil {
+		return nil, err
+	}
 
-		if filter.EndDate.After(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)) {
-			req = req.SetQueryParam("end-date", filter.EndDate.Format(time.RFC3339))
-		}
+	resp, err := client.R().
+		Delete(fmt.Sprintf("/accounts/%s/orders/%s", accountNumber, orderID))
+	if err != nil {
+		return nil, err
+	}
 
-		if filter.Symbol != "" {
-			req = req.SetQueryParam("symbol", filter.Symbol)
+	if resp.StatusCode() >= 400 {
+		httpErr := fmt.Errorf("%w %s (delete order): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+		if !opt.IgnoreTerminal || resp.StatusCode() >= 500 {
+			return nil, httpErr
 		}
 
-		if filter.InstrumentType != UndefinedInstrument {
-			req = req.SetQueryParam("instrument-type", filter.InstrumentType.String())
+		// the order may not be cancellable because it is already terminal
+		orderStatus, err = session.GetOrder(accountNumber, orderID)
+		if err != nil || !orderStatus.IsTerminal() {
+			return nil, httpErr
 		}
 
-		if filter.UnderlyingSymbol != "" {
-			req = req.SetQueryParam("underlying-symbol", filter.UnderlyingSymbol)
-		}
+		return orderStatus, nil
+	}
 
-		if filter.Action != UndefinedAction {
-			req = req.SetQueryParam("action", filter.Action.String())
-		}
+	content := string(resp.Body())
+	order := gjson.Get(content, "data.order")
 
-		if filter.PartitionKey != "" {
-			req = req.SetQueryParam("partition-key", filter.PartitionKey)
-		}
+	return parseOrderStatus(order), nil
+}
 
-		if filter.FuturesSymbol != "" {
-			req = req.SetQueryParam("futures-symbol", filter.FuturesSymbol)
-		}
+// FlattenAccount cancels all of the account's live orders and then submits
+// an order to close each open position. orderType must be Market, Limit, or
+// MarketableLimit. Limit and marketable limit orders are priced from
+// FlattenAccountOpts.Prices, which must have a price for every open position.
+// Position.ClosePrice is never used: it is the previous session's close and
+// is stale during the trading day, so a limit order priced at it may not
+// fill or may fill far from the market. Because this is destructive it
+// returns ErrFlattenNotConfirmed unless FlattenAccountOpts.Confirm is set.
+//
+// Closing orders are built before anything is cancelled: if a position has a
+// fractional quantity, or a limit order is requested for a position without
+// a price, an error is returned and no orders are cancelled or submitted.
+// The responses of the closing orders submitted before any submission error
+// are returned.
+func (session *Session) FlattenAccount(accountNumber string, orderType OrderTypeChoice, opts ...FlattenAccountOpts) ([]*OrderResponse, error) {
+	var opt FlattenAccountOpts
+	if len(opts) > 0 {
+		opt = opts[0]
 	}
 
-	resp, err := req.Get(fmt.Sprintf("/accounts/%s/transactions", accountNumber))
-	if err != nil {
-		return nil, err
+	if !opt.Confirm {
+		return nil, ErrFlattenNotConfirmed
 	}
 
-	if resp.StatusCode() >= 400 {
-		return nil, fmt.Errorf("%w %s (transactions): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+	switch orderType {
+	case Market, Limit, MarketableLimit:
+	default:
+		return nil, fmt.Errorf("%w: cannot flatten account with %s orders", ErrInvalidOrder, orderType)
 	}
 
-	arr := gjson.Get(string(resp.Body()), "data.items").Array()
-	transactions := make([]*Transaction, len(arr))
-	for idx, trx := range arr {
-		instrumentType := InstrumentTypeFromString(trx.Get("instrument-type").String())
-		actionType := ActionTypeFromString(trx.Get("action").String())
-		valueEffect := EffectFromString(trx.Get("value-effect").String())
-		regulatoryFeesEffect := EffectFromString(trx.Get("regulatory-fees-effect").String())
-		clearingFeesEffect := EffectFromString(trx.Get("clearing-fees-effect").String())
-		otherChargeEffect := EffectFromString(trx.Get("other-charge-effect").String())
-		netValueEffect := EffectFromString(trx.Get("net-value-effect").String())
-		commissionEffect := EffectFromString(trx.Get("commission-effect").String())
-		proprietaryIndexOptionFeesEffect := EffectFromString(trx.Get("proprietary-index-option-fees-effect").String())
-
-		lotArr := trx.Get("lots").Array()
-		lots := make([]*Lot, len(lotArr))
-		for idx2, lot := range lotArr {
-			lots[idx2] = &Lot{
-				ID:                lot.Get("id").String(),
-				TransactionID:     lot.Get("transaction-id").Int(),
-				Quantity:          lot.Get("quantity").Float(),
-				Price:             lot.Get("price").Float(),
-				QuantityDirection: lot.Get("quantity-direction").String(),
-				ExecutedAt:        lot.Get("executed-at").Time(),
-				TransactionDate:   asDate(lot.Get("transaction-date").String()),
-			}
-		}
+	positions, err := session.Positions(accountNumber)
+	if err != nil {
+		return nil, err
+	}
 
-		transactions[idx] = &Transaction{
-			ID:                               trx.Get("id").Int(),
-			AccountNumber:                    trx.Get("account-number").String(),
-			ExecutedAt:                       trx.Get("executed-at").Time(),
-			TransactionDate:                  asDate(trx.Get("transaction-date").String()),
-			TransactionType:                  trx.Get("transaction-type").String(),
-			TransactionSubType:               trx.Get("transaction-sub-type").String(),
-			Description:                      trx.Get("description").String(),
-			UnderlyingSymbol:                 trx.Get("underlying-symbol").String(),
-			InstrumentType:                   instrumentType,
-			Symbol:                           trx.Get("symbol").String(),
-			Action:                           actionType,
-			Quantity:                         trx.Get("quantity").Float(),
-			Price:                            trx.Get("price").Float(),
-			Value:                            trx.Get("value").Float(),
-			ValueEffect:                      valueEffect,
-			RegulatoryFees:                   trx.Get("regulatory-fees").Float(),
-			RegulatoryFeesEffect:             regulatoryFeesEffect,
-			ClearingFees:                     trx.Get("clearing-fees").Float(),
-			ClearingFeesEffect:               clearingFeesEffect,
-			OtherCharge:                      trx.Get("other-charge").Float(),
-			OtherChargeEffect:                otherChargeEffect,
-			OtherChargeDescription:           trx.Get("other-charge-description").String(),
-			NetValue:                         trx.Get("net-value").Float(),
-			NetValueEffect:                   netValueEffect,
-			Commission:                       trx.Get("commission").Float(),
-			CommissionEffect:                 commissionEffect,
-			ProprietaryIndexOptionFees:       trx.Get("proprietary-index-option-fees").Float(),
-			ProprietaryIndexOptionFeesEffect: proprietaryIndexOptionFeesEffect,
-			IsEstimatedFee:                   trx.Get("is-estimated-fee").Bool(),
-			OrderID:                          trx.Get("order-id").Int(),
-			Lots:                             lots,
-			LegCount:                         trx.Get("leg-count").Int(),
-			DestinationVenue:                 trx.Get("destination-venue").String(),
-			AgencyPrice:                      trx.Get("agency-price").Float(),
-			PrincipalPrice:                   trx.Get("principal-price").Float(),
-			ExternalExchangeOrderNumber:      trx.Get("ext-exchange-order-number").String(),
-			ExternalGlobalOrderNumber:        trx.Get("ext-global-order-number").Int(),
-			ExternalGroupID:                  trx.Get("ext-group-id").String(),
-			ExternalGroupFillID:              trx.Get("ext-group-fill-id").String(),
-			ExternalExecutionID:              trx.Get("ext-exec-id").String(),
-			ExecutionID:                      trx.Get("exec-id").String(),
-			Exchange:                         trx.Get("exchange").String(),
-			ReversesID:                       trx.Get("reverses-id").Int(),
-			ExchangeAffiliationID:            trx.Get("exchange-affiliation-identifier").String(),
-			CostBasisReconciliationDate:      asDate(trx.Get("cost-basis-reconciliation-date").String()),
+	closingOrders := make([]*Order, 0, len(positions))
+	for _, position := range FilterOpenOnly(positions) {
+		order, err := closingOrder(position, orderType, opt.Prices[position.Symbol])
+		if err != nil {
+			return nil, err
 		}
+		closingOrders = append(closingOrders, order)
 	}
 
-	return transactions, nil
-}
-
-// Orders returns a paginated list of the accounts's orders
-func (session *Session) Orders(accountNumber string, filterOpts ...OrdersFilterOpts) ([]*OrderStatus, error) {
 	client, err := session.restyClient()
 	if err != nil {
 		return nil, err
 	}
 
-	req := client.R()
+	liveOrders, _, err := fetchItems(client.R(), fmt.Sprintf("/accounts/%s/orders/live", accountNumber), "live orders", parseOrderStatus)
+	if err != nil {
+		return nil, err
+	}
 
-	// set parameters from filterOpts
-	if len(filterOpts) > 1 {
-		filter := filterOpts[0]
+	for _, order := range liveOrders {
+		if order.IsTerminal() {
+			continue
+		}
 
-		if filter.PerPage > 0 {
-			req = req.SetQueryParam("per-page", fmt.Sprint(filter.PerPage))
+		if _, err := session.DeleteOrder(accountNumber, order.ID, DeleteOrderOpts{IgnoreTerminal: true}); err != nil {
+			return nil, err
 		}
+	}
 
-		if filter.PageOffset > 0 {
-			req = req.SetQueryParam("page-offset", fmt.Sprint(filter.PageOffset))
+	responses := make([]*OrderResponse, 0, len(closingOrders))
+	for _, order := range closingOrders {
+		resp, err := session.SubmitOrder(accountNumber, order)
+		if err != nil {
+			return responses, err
 		}
 
-		req = req.SetQueryParam("sort", filter.Sort.String())
+		responses = append(responses, resp)
+	}
 
-		if len(filter.Status) > 0 {
-			req = req.SetQueryParamsFromValues(url.Values{
-				"status[]": filter.Status,
-			})
-		}
+	return responses, nil
+}
 
-		if filter.StartDate.After(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)) {
-			req = req.SetQueryParam("start-date", filter.StartDate.Format(time.RFC3339))
-		}
+// closingOrder builds a day order of orderType that closes position. price
+// is the limit price and is ignored for market orders.
+func closingOrder(position *Position, orderType OrderTypeChoice, price float64) (*Order, error) {
+	quantity := math.Abs(position.Quantity)
+	if quantity != math.Trunc(quantity) {
+		return nil, fmt.Errorf("%w: cannot close fractional quantity %v of %s", ErrInvalidOrder, position.Quantity, position.Symbol)
+	}
 
-		if filter.EndDate.After(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)) {
-			req = req.SetQueryParam("end-date", filter.EndDate.Format(time.RFC3339))
-		}
+	instrumentType := InstrumentTypeFromString(position.InstrumentType)
+	short := position.QuantityDirection == "Short"
+
+	var action ActionType
+	switch {
+	case instrumentType == Future && short:
+		action = Buy
+	case instrumentType == Future:
+		action = Sell
+	case short:
+		action = BuyToClose
+	default:
+		action = SellToClose
+	}
 
-		if filter.UnderlyingSymbol != "" {
-			req = req.SetQueryParam("underlying-symbol", filter.UnderlyingSymbol)
-		}
+	order := &Order{
+		TimeInForce: Day,
+		OrderType:   orderType,
+		Legs: []*Leg{
+			{
+				InstrumentType: instrumentType,
+				Symbol:         position.Symbol,
+				Quantity:       int64(quantity),
+				Action:         action,
+			},
+		},
+	}
 
-		if filter.UnderlyingInstrumentType != UndefinedInstrument {
-			req = req.SetQueryParam("underlying-instrument-type", filter.UnderlyingInstrumentType.String())
+	if orderType != Market {
+		if price <= 0 {
+			return nil, fmt.Errorf("%w: no price given for a %s order for %s", ErrNoPriceAvailable, orderType, position.Symbol)
 		}
 
-		if filter.FuturesSymbol != "" {
-			req = req.SetQueryParam("futures-symbol", filter.FuturesSymbol)
+		order.Price = price
+		order.PriceEffect = Credit
+		if short {
+			order.PriceEffect = Debit
 		}
 	}
 
-	resp, err := req.Get(fmt.Sprintf("/accounts/%s/orders", accountNumber))
-	if err != nil {
-		return nil, err
-	}
+	return order, nil
+}
 
-	if resp.StatusCode() >= 400 {
-		return nil, fmt.Errorf("%w %s (orders): %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+// auditOrder passes event to the session's OrderAuditor, if any
+func (session *Session) auditOrder(event *OrderEvent) {
+	if session.Auditor == nil {
+		return
 	}
 
-	arr := gjson.Get(string(resp.Body()), "data.items").Array()
-	orders := make([]*OrderStatus, len(arr))
-	for idx, order := range arr {
-		orders[idx] = parseOrderStatus(order)
+	event.Time = time.Now()
+	if event.OrderID == "" && event.Status != nil {
+		event.OrderID = event.Status.ID
 	}
 
-	return orders, nil
+	session.Auditor.OnOrderEvent(event)
 }
 
-// SubmitOrder sends the specified order to tastytrade for execution
-func (session *Session) SubmitOrder(accountNumber string, order *Order) (*OrderResponse, error) {
-	client, err := session.restyClient()
-	if err != nil {
-		return nil, err
+// accountHTTPError builds the error returned for a failed request to an
+// account-scoped endpoint. A 404 means the account number is invalid or not
+// accessible to the session and wraps ErrAccountNotFound in addition to
+// ErrInvalidHTTPResponse.
+func accountHTTPError(resp *resty.Response, name string) error {
+	if resp.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %w %s (%s): %s", ErrAccountNotFound, ErrInvalidHTTPResponse, resp.Status(), name, resp.Body())
 	}
 
-	resp, err := client.R().
-		SetBody(order).
-		Post(fmt.Sprintf("/accounts/%s/orders", accountNumber))
+	return fmt.Errorf("%w %s (%s): %s", ErrInvalidHTTPResponse, resp.Status(), name, resp.Body())
+}
+
+// fetchItems sends req to the account-scoped path and parses each element of
+// the response's data.items array with parse. name is used to identify the
+// endpoint in errors.
+func fetchItems[T any](req *resty.Request, path, name string, parse func(gjson.Result) T) ([]T, *Pagination, error) {
+	resp, err := req.Get(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if resp.StatusCode() >= 400 {
-		return nil, fmt.Errorf("%w %s: %s", ErrInvalidHTTPResponse, resp.Status(), resp.Body())
+		return nil, nil, accountHTTPError(resp, name)
 	}
 
-	content := string(resp.Body())
-	orderStatus := gjson.Get(content, "data.order")
+	body := string(resp.Body())
+	arr := gjson.Get(body, "data.items").Array()
+	items := make([]T, len(arr))
+	for idx, item := range arr {
+		items[idx] = parse(item)
+	}
 
-	return &OrderResponse{
-		Order:               parseOrderStatus(orderStatus),
-		EffectOnBuyingPower: parseEffectOnBuyingPower(gjson.Get(content, "data.buying-power-effect")),
-		FeeCalculation:      parseFeeInfo(gjson.Get(content, "data.fee-calculation")),
-		Errors:              parseErrors(gjson.Get(content, "data.errors").Array()),
-		Warnings:            parseErrors(gjson.Get(content, "data.warnings").Array()),
-	}, nil
+	return items, parsePagination(gjson.Get(body, "pagination")), nil
 }
 
-// DeleteOrder attempts to delete orderID
-func (session *Session) DeleteOrder(accountNumber string, orderID string) (*OrderStatus, error) {
-	client, err := session.restyClient()
-	if err != nil {
-		return nil, err
+func parsePagination(pagination gjson.Result) *Pagination {
+	if !pagination.Exists() {
+		return nil
 	}
 
-	resp, err := client.R().
-		Delete(fmt.Sprintf("/sessions/%s/orders/%s", accountNumber, orderID))
-	if err != nil {
-		return nil, err
+	return &Pagination{
+		PerPage:          int(pagination.Get("per-page").Int()),
+		PageOffset:       int(pagination.Get("page-offset").Int()),
+		ItemOffset:       int(pagination.Get("item-offset").Int()),
+		TotalItems:       int(pagination.Get("total-items").Int()),
+		TotalPages:       int(pagination.Get("total-pages").Int()),
+		CurrentItemCount: int(pagination.Get("current-item-count").Int()),
 	}
+}
 
-	content := string(resp.Body())
-	order := gjson.Get(content, "data.order")
-	orderStatus := parseOrderStatus(order)
+func parseAccount(acct gjson.Result) *Account {
+	return &Account{
+		AccountNumber:     acct.Get("account.account-number").String(),
+		ExternalID:        acct.Get("account.external-id").String(),
+		OpenedAt:          asTime(acct.Get("account.opened-at").String()),
+		Nickname:          acct.Get("account.nickname").String(),
+		AccountType:       acct.Get("account.account-type-name").String(),
+		DayTraderStatus:   acct.Get("account.day-trader-status").Bool(),
+		MarginOrCash:      acct.Get("account.margin-or-cash").String(),
+		AuthorityLevel:    AuthorityLevelFromString(acct.Get("authority-level").String()),
+		IsFirmError:       acct.Get("account.is-firm-error").Bool(),
+		IsFirmProprietary: acct.Get("account.is-firm-proprietary").Bool(),
+		IsTestDrive:       acct.Get("account.is-test-drive").Bool(),
+		IsForeign:         acct.Get("account.is-foreign").Bool(),
+		FundingDate:       asTime(acct.Get("account.funding-date").String()),
+	}
+}
+
+func parsePosition(pos gjson.Result) *Position {
+	return &Position{
+		AccountNumber:                 pos.Get("account-number").String(),
+		Symbol:                        pos.Get("symbol").String(),
+		InstrumentType:                pos.Get("instrument-type").String(),
+		UnderlyingSymbol:              pos.Get("underlying-symbol").String(),
+		UnderlyingProductCode:         pos.Get("underlying-product-code").String(),
+		Quantity:                      pos.Get("quantity").Float(),
+		QuantityDirection:             pos.Get("quantity-direction").String(),
+		ClosePrice:                    pos.Get("close-price").Float(),
+		AverageOpenPrice:              pos.Get("average-open-price").Float(),
+		AverageYearlyMarketClosePrice: pos.Get("average-yearly-market-close-price").Float(),
+		AverageDailyMarketClosePrice:  pos.Get("average-daily-market-close-price").Float(),
+		Multiplier:                    pos.Get("multiplier").Float(),
+		CostEffect:                    pos.Get("cost-effect").String(),
+		IsSuppressed:                  pos.Get("is-suppressed").Bool(),
+		IsFrozen:                      pos.Get("is-frozen").Bool(),
+		RestrictedQuantity:            pos.Get("restricted-quantity").Float(),
+		RealizedDayGain:               pos.Get("realized-day-gain").Float(),
+		RealizedDayGainEffect:         pos.Get("realized-day-gain-effect").String(),
+		RealizedDayGainDate:           pos.Get("realized-day-gain-date").Time(),
+		RealizedToday:                 pos.Get("realized-today").Float(),
+		RealizedTodayEffect:           pos.Get("realized-today-effect").String(),
+		RealizedTodayDate:             pos.Get("realized-today-date").Time(),
+		ExpiresAt:                     pos.Get("expires-at").Time(),
+		CreatedAt:                     pos.Get("created-at").Time(),
+		UpdatedAt:                     pos.Get("updated-at").Time(),
+	}
+}
+
+func parseTransaction(trx gjson.Result) *Transaction {
+	instrumentType := InstrumentTypeFromString(trx.Get("instrument-type").String())
+	actionType := ActionTypeFromString(trx.Get("action").String())
+	valueEffect := parseEffect(trx, "value-effect")
+	regulatoryFeesEffect := parseEffect(trx, "regulatory-fees-effect")
+	clearingFeesEffect := parseEffect(trx, "clearing-fees-effect")
+	otherChargeEffect := parseEffect(trx, "other-charge-effect")
+	netValueEffect := parseEffect(trx, "net-value-effect")
+	commissionEffect := parseEffect(trx, "commission-effect")
+	proprietaryIndexOptionFeesEffect := parseEffect(trx, "proprietary-index-option-fees-effect")
+
+	lotArr := trx.Get("lots").Array()
+	lots := make([]*Lot, len(lotArr))
+	for idx, lot := range lotArr {
+		lots[idx] = &Lot{
+			ID:                lot.Get("id").String(),
+			TransactionID:     lot.Get("transaction-id").Int(),
+			Quantity:          lot.Get("quantity").Float(),
+			Price:             lot.Get("price").Float(),
+			QuantityDirection: lot.Get("quantity-direction").String(),
+			ExecutedAt:        lot.Get("executed-at").Time(),
+			TransactionDate:   asDate(lot.Get("transaction-date").String()),
+		}
+	}
+
+	return &Transaction{
+		ID:                               trx.Get("id").Int(),
+		AccountNumber:                    trx.Get("account-number").String(),
+		ExecutedAt:                       trx.Get("executed-at").Time(),
+		TransactionDate:                  asDate(trx.Get("transaction-date").String()),
+		TransactionType:                  trx.Get("transaction-type").String(),
+		TransactionSubType:               trx.Get("transaction-sub-type").String(),
+		Description:                      trx.Get("description").String(),
+		UnderlyingSymbol:                 trx.Get("underlying-symbol").String(),
+		InstrumentType:                   instrumentType,
+		Symbol:                           trx.Get("symbol").String(),
+		Action:                           actionType,
+		Quantity:                         trx.Get("quantity").Float(),
+		Price:                            trx.Get("price").Float(),
+		Value:                            trx.Get("value").Float(),
+		ValueEffect:                      valueEffect,
+		RegulatoryFees:                   trx.Get("regulatory-fees").Float(),
+		RegulatoryFeesEffect:             regulatoryFeesEffect,
+		ClearingFees:                     trx.Get("clearing-fees").Float(),
+		ClearingFeesEffect:               clearingFeesEffect,
+		OtherCharge:                      trx.Get("other-charge").Float(),
+		OtherChargeEffect:                otherChargeEffect,
+		OtherChargeDescription:           trx.Get("other-charge-description").String(),
+		NetValue:                         trx.Get("net-value").Float(),
+		NetValueEffect:                   netValueEffect,
+		Commission:                       trx.Get("commission").Float(),
+		CommissionEffect:                 commissionEffect,
+		ProprietaryIndexOptionFees:       trx.Get("proprietary-index-option-fees").Float(),
+		ProprietaryIndexOptionFeesEffect: proprietaryIndexOptionFeesEffect,
+		IsEstimatedFee:                   trx.Get("is-estimated-fee").Bool(),
+		OrderID:                          trx.Get("order-id").Int(),
+		Lots:                             lots,
+		LegCount:                         trx.Get("leg-count").Int(),
+		DestinationVenue:                 trx.Get("destination-venue").String(),
+		AgencyPrice:                      trx.Get("agency-price").Float(),
+		PrincipalPrice:                   trx.Get("principal-price").Float(),
+		ExternalExchangeOrderNumber:      trx.Get("ext-exchange-order-number").String(),
+		ExternalGlobalOrderNumber:        trx.Get("ext-global-order-number").Int(),
+		ExternalGroupID:                  trx.Get("ext-group-id").String(),
+		ExternalGroupFillID:              trx.Get("ext-group-fill-id").String(),
+		ExternalExecutionID:              trx.Get("ext-exec-id").String(),
+		ExecutionID:                      trx.Get("exec-id").String(),
+		Exchange:                         trx.Get("exchange").String(),
+		ReversesID:                       trx.Get("reverses-id").Int(),
+		ExchangeAffiliationID:            trx.Get("exchange-affiliation-identifier").String(),
+		CostBasisReconciliationDate:      asDate(trx.Get("cost-basis-reconciliation-date").String()),
+	}
+}
 
-	return orderStatus, nil
+func parseOrderResponse(result gjson.Result) *OrderResponse {
+	return &OrderResponse{
+		Order:               parseOrderStatus(result.Get("order")),
+		EffectOnBuyingPower: parseEffectOnBuyingPower(result.Get("buying-power-effect")),
+		FeeCalculation:      parseFeeInfo(result.Get("fee-calculation")),
+		Errors:              parseErrors(result.Get("errors").Array()),
+		Warnings:            parseErrors(result.Get("warnings").Array()),
+	}
 }
 
 func parseOrderStatus(order gjson.Result) *OrderStatus {
 	underlyingInstrumentType := InstrumentTypeFromString(order.Get("underlying-instrument-type").String())
-	valueEffect := EffectFromString(order.Get("value-effect").String())
-	priceEffect := EffectFromString(order.Get("price-effect").String())
+	valueEffect := parseEffect(order, "value-effect")
+	priceEffect := parseEffect(order, "price-effect")
 	orderType := OrderTypeFromString(order.Get("order-type").String())
 
 	legArr := order.Get("legs").Array()
@@ -896,7 +2616,9 @@ func parseOrderStatus(order gjson.Result) *OrderStatus {
 			Symbol:            leg.Get("symbol").String(),
 			Quantity:          leg.Get("quantity").String(),
 			RemainingQuantity: leg.Get("remaining-quantity").String(),
+			QuantityDirection: leg.Get("quantity-direction").String(),
 			Action:            actionType,
+			Price:             leg.Get("price").Float(),
 			Fills:             fills,
 		}
 	}
@@ -954,7 +2676,7 @@ func parseOrderStatus(order gjson.Result) *OrderStatus {
 		TimeInForce:              TimeInForceFromString(order.Get("time-in-force").String()),
 		TerminalAt:               order.Get("terminal-at").Time(),
 		Editable:                 order.Get("editable").Bool(),
-		ContingentStatus:         order.Get("contingent-status").String(),
+		ContingentStatus:         ContingentStatusFromString(order.Get("contingent-status").String()),
 		Legs:                     legs,
 		GTCDate:                  order.Get("gtc-date").Time(),
 		UpdatedAt:                order.Get("updated-at").String(),
@@ -965,7 +2687,7 @@ func parseOrderStatus(order gjson.Result) *OrderStatus {
 		Price:                    order.Get("price").Float(),
 		CancelUsername:           order.Get("cancel-username").String(),
 		AccountNumber:            order.Get("account-number").String(),
-		ConfirmationStatus:       order.Get("confirmation-status").String(),
+		ConfirmationStatus:       ConfirmationStatusFromString(order.Get("confirmation-status").String()),
 		CancelUserID:             order.Get("cancel-user-id").String(),
 		Cancellable:              order.Get("cancellable").Bool(),
 		ValueEffect:              valueEffect,
@@ -992,36 +2714,107 @@ func parseOrderStatus(order gjson.Result) *OrderStatus {
 	return orderStatus
 }
 
+func parseBalance(result gjson.Result) *Balance {
+	return &Balance{
+		AccountNumber:                      result.Get("account-number").String(),
+		CashBalance:                        result.Get("cash-balance").Float(),
+		LongEquityValue:                    result.Get("long-equity-value").Float(),
+		ShortEquityValue:                   result.Get("short-equity-value").Float(),
+		LongDerivativeValue:                result.Get("long-derivative-value").Float(),
+		ShortDerivativeValue:               result.Get("short-derivative-value").Float(),
+		LongFuturesValue:                   result.Get("long-futures-value").Float(),
+		ShortFuturesValue:                  result.Get("short-futures-value").Float(),
+		LongFuturesDerivativeValue:         result.Get("long-futures-derivative-value").Float(),
+		ShortFuturesDerivativeValue:        result.Get("short-futures-derivative-value").Float(),
+		LongMargineableValue:               result.Get("long-margineable-value").Float(),
+		ShortMargineableValue:              result.Get("short-margineable-value").Float(),
+		MarginEquity:                       result.Get("margin-equity").Float(),
+		EquityBuyingPower:                  result.Get("equity-buying-power").Float(),
+		DerivativeBuyingPower:              result.Get("derivative-buying-power").Float(),
+		DayTradingBuyingPower:              result.Get("day-trading-buying-power").Float(),
+		FuturesMarginRequirement:           result.Get("futures-margin-requirement").Float(),
+		AvailableTradingFunds:              result.Get("available-trading-funds").Float(),
+		MaintenanceRequirement:             result.Get("maintenance-requirement").Float(),
+		MaintenanceCallValue:               result.Get("maintenance-call-value").Float(),
+		RegTCallValue:                      result.Get("reg-t-call-value").Float(),
+		DayTradingCallValue:                result.Get("day-trading-call-value").Float(),
+		DayEquityCallValue:                 result.Get("day-equity-call-value").Float(),
+		NetLiquidatingValue:                result.Get("net-liquidating-value").Float(),
+		CashAvailableToWithdraw:            result.Get("cash-available-to-withdraw").Float(),
+		DayTradeExcess:                     result.Get("day-trade-excess").Float(),
+		PendingCash:                        result.Get("pending-cash").Float(),
+		PendingCashEffect:                  result.Get("pending-cash-effect").String(),
+		LongCryptocurrencyValue:            result.Get("long-cryptocurrency-value").Float(),
+		ShortCryptocurrencyValue:           result.Get("short-cryptocurrency-value").Float(),
+		CryptocurrencyMarginRequirement:    result.Get("cryptocurrency-margin-requirement").Float(),
+		UnsettledCryptocurrencyFiatAmount:  result.Get("unsettled-cryptocurrency-fiat-amount").Float(),
+		UnsettledCryptocurrencyFiatEffect:  result.Get("unsettled-cryptocurrency-fiat-effect").String(),
+		ClosedLoopAvailableBalance:         result.Get("closed-loop-available-balance").Float(),
+		EquityOfferingMarginRequirement:    result.Get("equity-offering-margin-requirement").Float(),
+		LongBondValue:                      result.Get("long-bond-value").Float(),
+		BondMarginRequirement:              result.Get("bond-margin-requirement").Float(),
+		UsedDerivativeBuyingPower:          result.Get("used-derivative-buying-power").Float(),
+		SnapshotDate:                       asTime(result.Get("snapshot-date").String()),
+		RegTMarginRequirement:              result.Get("reg-t-margin-requirement").Float(),
+		FuturesOvernightMarginRequirement:  result.Get("futures-overnight-margin-requirement").Float(),
+		FuturesIntradayMarginRequirement:   result.Get("futures-intraday-margin-requirement").Float(),
+		MaintenanceExcess:                  result.Get("maintenance-excess").Float(),
+		PendingMarginInterest:              result.Get("pending-margin-interest").Float(),
+		EffectiveCryptocurrencyBuyingPower: result.Get("effective-cryptocurrency-buying-power").Float(),
+		UpdatedAt:                          result.Get("updated-at").Time(),
+	}
+}
+
+func parseOptionInstrument(option gjson.Result) *OptionInstrument {
+	return &OptionInstrument{
+		Symbol:            option.Get("symbol").String(),
+		StreamerSymbol:    option.Get("streamer-symbol").String(),
+		UnderlyingSymbol:  option.Get("underlying-symbol").String(),
+		RootSymbol:        option.Get("root-symbol").String(),
+		OptionType:        option.Get("option-type").String(),
+		StrikePrice:       option.Get("strike-price").Float(),
+		ExpirationDate:    asDate(option.Get("expiration-date").String()),
+		ExpiresAt:         option.Get("expires-at").Time(),
+		DaysToExpiration:  option.Get("days-to-expiration").Int(),
+		ExerciseStyle:     option.Get("exercise-style").String(),
+		ExpirationType:    option.Get("expiration-type").String(),
+		SettlementType:    option.Get("settlement-type").String(),
+		SharesPerContract: option.Get("shares-per-contract").Float(),
+		Active:            option.Get("active").Bool(),
+		IsClosingOnly:     option.Get("is-closing-only").Bool(),
+	}
+}
+
 func parseEffectOnBuyingPower(result gjson.Result) *BuyingPowerChange {
 	return &BuyingPowerChange{
 		ChangeInMarginRequirement:            result.Get("change-in-margin-requirement").Float(),
-		ChangeInMarginRequirementEffect:      EffectFromString(result.Get("change-in-margin-requirement-effect").String()),
+		ChangeInMarginRequirementEffect:      parseEffect(result, "change-in-margin-requirement-effect"),
 		ChangeInBuyingPower:                  result.Get("change-in-buying-power").Float(),
-		ChangeInBuyingPowerEffect:            EffectFromString(result.Get("change-in-buying-power-effect").String()),
+		ChangeInBuyingPowerEffect:            parseEffect(result, "change-in-buying-power-effect"),
 		CurrentBuyingPower:                   result.Get("current-buying-power").Float(),
-		CurrentBuyingPowerEffect:             EffectFromString(result.Get("current-buying-power-effect").String()),
+		CurrentBuyingPowerEffect:             parseEffect(result, "current-buying-power-effect"),
 		NewBuyingPower:                       result.Get("new-buying-power").Float(),
-		NewBuyingPowerEffect:                 EffectFromString(result.Get("new-buying-power-effect").String()),
+		NewBuyingPowerEffect:                 parseEffect(result, "new-buying-power-effect"),
 		IsolatedOrderMarginRequirement:       result.Get("isolated-order-margin-requirement").Float(),
-		IsolatedOrderMarginRequirementEffect: EffectFromString(result.Get("isolated-order-margin-requirement-effect").String()),
+		IsolatedOrderMarginRequirementEffect: parseEffect(result, "isolated-order-margin-requirement-effect"),
 		IsSpread:                             result.Get("is-spread").Bool(),
 		Impact:                               result.Get("impact").Float(),
-		EffectOnCash:                         EffectFromString(result.Get("effect").String()),
+		EffectOnCash:                         parseEffect(result, "effect"),
 	}
 }
 
 func parseFeeInfo(result gjson.Result) *FeeInfo {
 	return &FeeInfo{
 		RegulatoryFees:                   result.Get("regulatory-fees").Float(),
-		RegulatoryFeesEffect:             EffectFromString(result.Get("regulatory-fees-effect").String()),
+		RegulatoryFeesEffect:             parseEffect(result, "regulatory-fees-effect"),
 		ClearingFees:                     result.Get("clearing-fees").Float(),
-		ClearingFeesEffect:               EffectFromString(result.Get("clearing-fees-effect").String()),
+		ClearingFeesEffect:               parseEffect(result, "clearing-fees-effect"),
 		Commission:                       result.Get("commission").Float(),
-		CommissionEffect:                 EffectFromString(result.Get("commission-effect").String()),
+		CommissionEffect:                 parseEffect(result, "commission-effect"),
 		ProprietaryIndexOptionFees:       result.Get("proprietary-index-option-fees").Float(),
-		ProprietaryIndexOptionFeesEffect: EffectFromString(result.Get("proprietary-index-option-fees-effect").String()),
+		ProprietaryIndexOptionFeesEffect: parseEffect(result, "proprietary-index-option-fees-effect"),
 		TotalFees:                        result.Get("total-fees").Float(),
-		TotalFeesEffect:                  EffectFromString(result.Get("total-fees-effect").String()),
+		TotalFeesEffect:                  parseEffect(result, "total-fees-effect"),
 	}
 }
 
@@ -1037,6 +2830,17 @@ func parseErrors(arr []gjson.Result) []*ErrorMsg {
 	return errorArr
 }
 
+// parseEffect reads the effect stored in key of result. Unrecognized values
+// are logged so that changes to the API are noticed.
+func parseEffect(result gjson.Result, key string) Effect {
+	effect, err := EffectFromStringStrict(result.Get(key).String())
+	if err != nil {
+		log.Warn().Err(err).Str("field", key).Msg("could not parse effect")
+	}
+
+	return effect
+}
+
 func asDate(input string) time.Time {
 	if input == "" {
 		return time.Time{}
@@ -1049,3 +2853,39 @@ func asDate(input string) time.Time {
 
 	return parsed
 }
+
+// loadToken returns the token stored in value or an empty string if no token
+// has been stored
+func loadToken(value *atomic.Value) string {
+	if value == nil {
+		return ""
+	}
+
+	token, _ := value.Load().(string)
+	return token
+}
+
+// timeLayouts are the formats timestamps and dates are returned in by the API
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.000-0700",
+	"2006-01-02",
+}
+
+// asTime parses input as either a timestamp or a date-only value
+func asTime(input string) time.Time {
+	if input == "" {
+		return time.Time{}
+	}
+
+	var err error
+	for _, layout := range timeLayouts {
+		var parsed time.Time
+		if parsed, err = time.Parse(layout, input); err == nil {
+			return parsed
+		}
+	}
+
+	log.Warn().Err(err).Str("raw", input).Msg("could not parse time string")
+	return time.Time{}
+}
@@ -0,0 +1,2693 @@
+// Copyright 2024
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gotasty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// newTestSession returns an authenticated session whose requests are served
+// by handler
+func newTestSession(t *testing.T, handler http.HandlerFunc) *Session {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	token := &atomic.Value{}
+	token.Store("test-session-token")
+	rememberToken := &atomic.Value{}
+	rememberToken.Store("")
+
+	return &Session{
+		BaseURL:            server.URL,
+		AccountStreamerURL: "ws" + strings.TrimPrefix(server.URL, "http"),
+		AuthenticatedOn:    time.Now(),
+		ExpiresOn:          time.Now().Add(24 * time.Hour),
+		Token:              token,
+		RememberToken:      rememberToken,
+		RefreshLocker:      &sync.Mutex{},
+		defaultAccount:     &atomic.Pointer[Account]{},
+	}
+}
+
+// requestLog records the method and path of each request received
+type requestLog struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (requestLog *requestLog) record(r *http.Request) {
+	requestLog.mu.Lock()
+	defer requestLog.mu.Unlock()
+	requestLog.requests = append(requestLog.requests, r.Method+" "+r.URL.Path)
+}
+
+func (requestLog *requestLog) all() []string {
+	requestLog.mu.Lock()
+	defer requestLog.mu.Unlock()
+	return append([]string{}, requestLog.requests...)
+}
+
+func TestFlattenAccountCancelsBeforeClosing(t *testing.T) {
+	var log requestLog
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/accounts/5WT0001/positions":
+			fmt.Fprint(w, `{"data":{"items":[
+				{"symbol":"AAPL","instrument-type":"Equity","quantity":10,"quantity-direction":"Long","close-price":180},
+				{"symbol":"/ESZ4","instrument-type":"Future","quantity":1,"quantity-direction":"Short","close-price":5000}
+			]}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/accounts/5WT0001/orders/live":
+			fmt.Fprint(w, `{"data":{"items":[{"id":"1","status":"Live"},{"id":"2","status":"Filled"}]}}`)
+		case r.Method == http.MethodDelete:
+			fmt.Fprint(w, `{"data":{"order":{"id":"1","status":"Cancel Requested"}}}`)
+		case r.Method == http.MethodPost:
+			fmt.Fprint(w, `{"data":{"order":{"id":"99","status":"Received"}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	responses, err := session.FlattenAccount("5WT0001", Market, FlattenAccountOpts{Confirm: true})
+	if err != nil {
+		t.Fatalf("FlattenAccount: %v", err)
+	}
+
+	if len(responses) != 2 {
+		t.Fatalf("expected 2 closing orders, got %d", len(responses))
+	}
+
+	want := []string{
+		"GET /accounts/5WT0001/positions",
+		"GET /accounts/5WT0001/orders/live",
+		"DELETE /accounts/5WT0001/orders/1",
+		"POST /accounts/5WT0001/orders",
+		"POST /accounts/5WT0001/orders",
+	}
+	if got := log.all(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected requests:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFlattenAccountLimitUsesGivenPrices(t *testing.T) {
+	var bodies []string
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/accounts/5WT0001/positions":
+			fmt.Fprint(w, `{"data":{"items":[
+				{"symbol":"AAPL","instrument-type":"Equity","quantity":10,"quantity-direction":"Long","close-price":180},
+				{"symbol":"/ESZ4","instrument-type":"Future","quantity":1,"quantity-direction":"Short","close-price":5000}
+			]}}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/accounts/5WT0001/orders/live":
+			fmt.Fprint(w, `{"data":{"items":[]}}`)
+		case r.Method == http.MethodPost:
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			fmt.Fprint(w, `{"data":{"order":{"id":"99","status":"Received"}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	opts := FlattenAccountOpts{Confirm: true, Prices: map[string]float64{"AAPL": 185.5, "/ESZ4": 5050.25}}
+	if _, err := session.FlattenAccount("5WT0001", Limit, opts); err != nil {
+		t.Fatalf("FlattenAccount: %v", err)
+	}
+
+	want := []string{
+		`"price":185.5,"price-effect":"Credit"`,
+		`"price":5050.25,"price-effect":"Debit"`,
+	}
+	if len(bodies) != len(want) {
+		t.Fatalf("expected %d closing orders, got %d", len(want), len(bodies))
+	}
+
+	for idx, body := range bodies {
+		if !strings.Contains(body, want[idx]) {
+			t.Errorf("expected closing order to contain %s, got %s", want[idx], body)
+		}
+	}
+}
+
+func TestFlattenAccountRequiresConfirmation(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	})
+
+	if _, err := session.FlattenAccount("5WT0001", Market); err != ErrFlattenNotConfirmed {
+		t.Errorf("expected ErrFlattenNotConfirmed, got %v", err)
+	}
+}
+
+func TestFlattenAccountRejectsUnclosablePositions(t *testing.T) {
+	tests := []struct {
+		name      string
+		orderType OrderTypeChoice
+		position  string
+	}{
+		{"fractional equity", Market, `{"symbol":"AAPL","instrument-type":"Equity","quantity":0.75,"quantity-direction":"Long","close-price":180}`},
+		{"fractional crypto", Market, `{"symbol":"BTC/USD","instrument-type":"Cryptocurrency","quantity":1.5,"quantity-direction":"Long","close-price":60000}`},
+		{"limit without a price", Limit, `{"symbol":"AAPL","instrument-type":"Equity","quantity":10,"quantity-direction":"Long","close-price":180}`},
+		{"marketable limit without a price", MarketableLimit, `{"symbol":"AAPL","instrument-type":"Equity","quantity":10,"quantity-direction":"Long","close-price":180}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != "/accounts/5WT0001/positions" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprintf(w, `{"data":{"items":[%s]}}`, tt.position)
+			})
+
+			opts := FlattenAccountOpts{Confirm: true, Prices: map[string]float64{"MSFT": 400}}
+			if _, err := session.FlattenAccount("5WT0001", tt.orderType, opts); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestSubmitOrderToOrderRoundTrip(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// echo the submitted order back as its status, which reports the
+		// rules as order-rule
+		status := strings.Replace(string(body), "{", `{"id":"42","status":"Received",`, 1)
+		status = strings.Replace(status, `"rules":`, `"order-rule":`, 1)
+		fmt.Fprintf(w, `{"data":{"order":%s}}`, status)
+	})
+
+	order := &Order{
+		TimeInForce: GTC,
+		OrderType:   Limit,
+		Price:       1.25,
+		PriceEffect: Credit,
+		Legs: []*Leg{
+			{InstrumentType: EquityOption, Symbol: "AAPL  240119C00190000", Quantity: 2, Action: SellToOpen},
+			{InstrumentType: EquityOption, Symbol: "AAPL  240119C00195000", Quantity: 2, Action: BuyToOpen},
+		},
+		OrderRules: &Rules{
+			RouteAfter: time.Date(2024, 1, 10, 14, 30, 0, 0, time.UTC),
+			CancelAt:   time.Date(2024, 1, 10, 21, 0, 0, 0, time.UTC),
+			Conditions: []*Condition{
+				{Action: Route, Symbol: "AAPL", InstrumentType: Equity, Indicator: Last, Comparator: GTE, Threshold: 185.5},
+			},
+		},
+	}
+
+	resp, err := session.SubmitOrder("5WT0001", order)
+	if err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+
+	roundTrip, err := resp.Order.ToOrder()
+	if err != nil {
+		t.Fatalf("ToOrder: %v", err)
+	}
+
+	if !reflect.DeepEqual(order, roundTrip) {
+		t.Errorf("round trip mismatch:\n got: %+v\nwant: %+v", roundTrip, order)
+	}
+
+	if !reflect.DeepEqual(order.OrderRules, roundTrip.OrderRules) {
+		t.Errorf("rules mismatch:\n got: %+v\nwant: %+v", roundTrip.OrderRules, order.OrderRules)
+	}
+
+	resp.Order.OrderRule = append(resp.Order.OrderRule, resp.Order.OrderRule[0])
+	if _, err := resp.Order.ToOrder(); !errors.Is(err, ErrInvalidOrder) {
+		t.Errorf("expected ErrInvalidOrder for multiple rules, got %v", err)
+	}
+}
+
+func TestReplaceOrder(t *testing.T) {
+	testCases := []struct {
+		name         string
+		response     string
+		wantWarnings int
+	}{
+		{"order response", `{"data":{"order":{"id":"43","status":"Received","order-type":"Limit","price":"5.5","replaces-order-id":"42"},"warnings":[{"code":"price_away","message":"price is far from market"}]}}`, 1},
+		{"order in data", `{"data":{"id":"43","status":"Received","order-type":"Limit","price":"5.5"}}`, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var log requestLog
+			var body string
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				log.record(r)
+				data, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(data)
+				fmt.Fprint(w, tc.response)
+			})
+
+			order := &Order{
+				TimeInForce: Day,
+				OrderType:   Limit,
+				Price:       5.5,
+				PriceEffect: Debit,
+				Legs:        []*Leg{{InstrumentType: Equity, Symbol: "SPY", Quantity: 1, Action: BuyToOpen}},
+			}
+
+			resp, err := session.ReplaceOrder("5WT0001", "42", order)
+			if err != nil {
+				t.Fatalf("ReplaceOrder: %v", err)
+			}
+
+			want := []string{"PUT /accounts/5WT0001/orders/42"}
+			if !reflect.DeepEqual(log.all(), want) {
+				t.Errorf("expected %v, got %v", want, log.all())
+			}
+
+			for _, field := range []string{`"order-type":"Limit"`, `"price":5.5`, `"price-effect":"Debit"`, `"symbol":"SPY"`} {
+				if !strings.Contains(body, field) {
+					t.Errorf("expected request body to contain %s, got %s", field, body)
+				}
+			}
+
+			if resp.Order.ID != "43" || resp.Order.Status != "Received" || resp.Order.Price != 5.5 {
+				t.Errorf("unexpected order: %+v", resp.Order)
+			}
+
+			if resp.Order.ReplacesOrderID != "42" {
+				t.Errorf("expected replaces order id 42, got %q", resp.Order.ReplacesOrderID)
+			}
+
+			if len(resp.Warnings) != tc.wantWarnings {
+				t.Errorf("expected %d warnings, got %d", tc.wantWarnings, len(resp.Warnings))
+			}
+		})
+	}
+}
+
+func TestPortfolioMarginStatus(t *testing.T) {
+	testCases := []struct {
+		name         string
+		response     string
+		wantEligible bool
+		wantEnabled  bool
+	}{
+		{"eligible", `{"data":{"account-number":"5WT0001","is-portfolio-margin-eligible":true,"is-portfolio-margin-enabled":false}}`, true, false},
+		{"ineligible", `{"data":{"account-number":"5WT0001","is-portfolio-margin-eligible":false,"is-portfolio-margin-enabled":false}}`, false, false},
+		{"enabled", `{"data":{"account-number":"5WT0001","is-portfolio-margin-enabled":true}}`, true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var log requestLog
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				log.record(r)
+				fmt.Fprint(w, tc.response)
+			})
+
+			status, err := session.PortfolioMarginStatus("5WT0001")
+			if err != nil {
+				t.Fatalf("PortfolioMarginStatus: %v", err)
+			}
+
+			want := &PortfolioMarginStatus{AccountNumber: "5WT0001", Eligible: tc.wantEligible, Enabled: tc.wantEnabled}
+			if *status != *want {
+				t.Errorf("expected %+v, got %+v", want, status)
+			}
+
+			wantRequests := []string{"GET /accounts/5WT0001/trading-status"}
+			if !reflect.DeepEqual(log.all(), wantRequests) {
+				t.Errorf("expected %v, got %v", wantRequests, log.all())
+			}
+		})
+	}
+}
+
+func TestOAuthRefreshExchangesRefreshToken(t *testing.T) {
+	testCases := []struct {
+		name        string
+		expiresIn   time.Duration
+		wantRefresh bool
+	}{
+		{"expired", -time.Hour, true},
+		{"about to expire", 2 * time.Minute, true},
+		{"valid", time.Hour, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var refreshes atomic.Int32
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/oauth/token":
+					refreshes.Add(1)
+					body, _ := io.ReadAll(r.Body)
+					if !strings.Contains(string(body), `"refresh_token":"refresh-me"`) || !strings.Contains(string(body), `"grant_type":"refresh_token"`) {
+						t.Errorf("unexpected token request body: %s", body)
+					}
+					fmt.Fprint(w, `{"access_token":"new-access","token_type":"Bearer","expires_in":900}`)
+				case "/customers/me/accounts":
+					want := "test-session-token"
+					if tc.wantRefresh {
+						want = "Bearer new-access"
+					}
+					if got := r.Header.Get("Authorization"); got != want {
+						t.Errorf("expected Authorization header %q, got %q", want, got)
+					}
+					fmt.Fprint(w, `{"data":{"items":[]}}`)
+				default:
+					http.NotFound(w, r)
+				}
+			})
+
+			session.ExpiresOn = time.Now().Add(tc.expiresIn)
+			session.OAuthRefreshToken = "refresh-me"
+			session.ClientSecret = "secret"
+
+			if _, err := session.Accounts(); err != nil {
+				t.Fatalf("Accounts: %v", err)
+			}
+
+			if want := map[bool]int32{true: 1, false: 0}[tc.wantRefresh]; refreshes.Load() != want {
+				t.Errorf("expected %d refreshes, got %d", want, refreshes.Load())
+			}
+
+			if !session.ExpiresOn.After(time.Now().Add(5 * time.Minute)) {
+				t.Errorf("expected token expiry more than 5 minutes away, got %s", session.ExpiresOn)
+			}
+		})
+	}
+}
+
+func TestConcurrentRequestsRefreshOnce(t *testing.T) {
+	var refreshes atomic.Int32
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			refreshes.Add(1)
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprint(w, `{"access_token":"new-access","token_type":"Bearer","expires_in":900}`)
+		default:
+			fmt.Fprint(w, `{"data":{"items":[]}}`)
+		}
+	})
+	session.ExpiresOn = time.Now().Add(-time.Hour)
+	session.OAuthRefreshToken = "refresh-me"
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 5; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := session.AuthorizationHeader(); err != nil {
+				t.Errorf("AuthorizationHeader: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := refreshes.Load(); n != 1 {
+		t.Errorf("expected a single refresh, got %d", n)
+	}
+}
+
+func TestDryRunMarginAggregatesOrders(t *testing.T) {
+	responses := []string{
+		`{"data":{"order":{"status":"Received"},
+			"buying-power-effect":{"change-in-margin-requirement":"500.0","change-in-margin-requirement-effect":"Debit",
+				"change-in-buying-power":"500.0","change-in-buying-power-effect":"Debit",
+				"current-buying-power":"10000.0","current-buying-power-effect":"Credit",
+				"new-buying-power":"9500.0","new-buying-power-effect":"Credit"},
+			"fee-calculation":{"total-fees":"1.0","total-fees-effect":"Debit"}}}`,
+		`{"data":{"order":{"status":"Received"},
+			"buying-power-effect":{"change-in-margin-requirement":"200.0","change-in-margin-requirement-effect":"Credit",
+				"change-in-buying-power":"200.0","change-in-buying-power-effect":"Credit",
+				"current-buying-power":"10000.0","current-buying-power-effect":"Credit",
+				"new-buying-power":"10200.0","new-buying-power-effect":"Credit"},
+			"fee-calculation":{"total-fees":"0.5","total-fees-effect":"Debit"}}}`,
+	}
+
+	var log requestLog
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		fmt.Fprint(w, responses[len(log.all())-1])
+	})
+
+	orders := []*Order{
+		{TimeInForce: Day, OrderType: Limit, Price: 5, PriceEffect: Debit, Legs: []*Leg{
+			{InstrumentType: Equity, Symbol: "SPY", Quantity: 100, Action: BuyToOpen},
+		}},
+		{TimeInForce: Day, OrderType: Limit, Price: 2, PriceEffect: Credit, Legs: []*Leg{
+			{InstrumentType: Equity, Symbol: "QQQ", Quantity: 100, Action: SellToClose},
+		}},
+	}
+
+	requirement, err := session.DryRunMargin("5WT0001", orders)
+	if err != nil {
+		t.Fatalf("DryRunMargin: %v", err)
+	}
+
+	if want := []string{"POST /accounts/5WT0001/orders/dry-run", "POST /accounts/5WT0001/orders/dry-run"}; !reflect.DeepEqual(log.all(), want) {
+		t.Errorf("expected %v, got %v", want, log.all())
+	}
+
+	got := *requirement
+	got.Orders = nil
+	want := MarginRequirement{
+		ChangeInMarginRequirement: 300,
+		ChangeInBuyingPower:       -300,
+		CurrentBuyingPower:        10000,
+		NewBuyingPower:            9700,
+		TotalFees:                 1.5,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	if len(requirement.Orders) != 2 {
+		t.Errorf("expected a response for each order, got %d", len(requirement.Orders))
+	}
+}
+
+func TestTransactionsForOrderSearchesEveryPage(t *testing.T) {
+	pages := []string{
+		`{"data":{"items":[{"id":1,"order-id":7},{"id":2,"order-id":8}]},
+			"pagination":{"per-page":2,"page-offset":0,"total-pages":2,"current-item-count":2}}`,
+		`{"data":{"items":[{"id":3,"order-id":7}]},
+			"pagination":{"per-page":2,"page-offset":1,"total-pages":2,"current-item-count":1}}`,
+	}
+
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		page := 0
+		fmt.Sscan(r.URL.Query().Get("page-offset"), &page)
+		fmt.Fprint(w, pages[page])
+	})
+
+	transactions, err := session.TransactionsForOrder("5WT0001", 7)
+	if err != nil {
+		t.Fatalf("TransactionsForOrder: %v", err)
+	}
+
+	ids := make([]int64, len(transactions))
+	for idx, trx := range transactions {
+		ids[idx] = trx.ID
+	}
+
+	if !reflect.DeepEqual(ids, []int64{1, 3}) {
+		t.Errorf("expected transactions 1 and 3, got %v", ids)
+	}
+}
+
+func TestActivityTimelineIncludesEveryOrderPage(t *testing.T) {
+	orderPages := []string{
+		`{"data":{"items":[{"id":1,"received-at":"2024-01-02T15:00:00Z"}]},
+			"pagination":{"per-page":1,"page-offset":0,"total-pages":2,"current-item-count":1}}`,
+		`{"data":{"items":[{"id":2,"received-at":"2024-01-03T15:00:00Z"}]},
+			"pagination":{"per-page":1,"page-offset":1,"total-pages":2,"current-item-count":1}}`,
+	}
+
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accounts/5WT0001/orders":
+			page := 0
+			fmt.Sscan(r.URL.Query().Get("page-offset"), &page)
+			fmt.Fprint(w, orderPages[page])
+		case "/accounts/5WT0001/transactions":
+			fmt.Fprint(w, `{"data":{"items":[]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	timeline, err := session.ActivityTimeline("5WT0001", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("ActivityTimeline: %v", err)
+	}
+
+	ids := make([]string, len(timeline))
+	for idx, event := range timeline {
+		ids[idx] = event.Order.ID
+	}
+
+	if !reflect.DeepEqual(ids, []string{"1", "2"}) {
+		t.Errorf("expected orders 1 and 2, got %v", ids)
+	}
+}
+
+func TestRememberTokenReadFromRememberTokenField(t *testing.T) {
+	const sessionsResponse = `{"data":{"session-token":"new-session-token","remember-token":"new-remember-token"}}`
+
+	newSession := func(t *testing.T) *Session {
+		session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/sessions":
+				fmt.Fprint(w, sessionsResponse)
+			default:
+				fmt.Fprint(w, `{"data":{"items":[]}}`)
+			}
+		})
+		session.RememberToken.Store("old-remember-token")
+		session.RememberMeExpiresOn = time.Now().Add(time.Hour)
+		return session
+	}
+
+	assertTokens := func(t *testing.T, session *Session) {
+		t.Helper()
+		if token := loadToken(session.Token); token != "new-session-token" {
+			t.Errorf("expected session token new-session-token, got %q", token)
+		}
+		if token := loadToken(session.RememberToken); token != "new-remember-token" {
+			t.Errorf("expected remember token new-remember-token, got %q", token)
+		}
+	}
+
+	t.Run("refresh", func(t *testing.T) {
+		session := newSession(t)
+		session.ExpiresOn = time.Now().Add(-time.Hour)
+
+		if _, err := session.Accounts(); err != nil {
+			t.Fatalf("Accounts: %v", err)
+		}
+		assertTokens(t, session)
+	})
+
+	t.Run("reauthenticate", func(t *testing.T) {
+		session := newSession(t)
+
+		if err := session.Reauthenticate("password"); err != nil {
+			t.Fatalf("Reauthenticate: %v", err)
+		}
+		assertTokens(t, session)
+	})
+}
+
+func TestSingleFilterSetsQueryParams(t *testing.T) {
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name  string
+		path  string
+		call  func(*Session) error
+		query url.Values
+	}{
+		{
+			name: "positions",
+			path: "/accounts/5WT0001/positions",
+			call: func(session *Session) error {
+				_, err := session.Positions("5WT0001", PositionFilterOpts{Symbol: "SPY", IncludeClosedPositions: true})
+				return err
+			},
+			query: url.Values{"symbol": {"SPY"}, "include-closed-positions": {"true"}},
+		},
+		{
+			name: "transactions",
+			path: "/accounts/5WT0001/transactions",
+			call: func(session *Session) error {
+				_, _, err := session.Transactions("5WT0001", TransactionFilterOpts{PerPage: 10, Symbol: "SPY", StartDate: start})
+				return err
+			},
+			query: url.Values{"per-page": {"10"}, "symbol": {"SPY"}, "start-date": {"2024-01-02T00:00:00Z"}},
+		},
+		{
+			name: "orders",
+			path: "/accounts/5WT0001/orders",
+			call: func(session *Session) error {
+				_, _, err := session.Orders("5WT0001", OrdersFilterOpts{UnderlyingSymbol: "SPY", Status: []string{"Filled"}})
+				return err
+			},
+			query: url.Values{"underlying-symbol": {"SPY"}, "status[]": {"Filled"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var query url.Values
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tc.path {
+					t.Errorf("expected request to %s, got %s", tc.path, r.URL.Path)
+				}
+				query = r.URL.Query()
+				fmt.Fprint(w, `{"data":{"items":[]}}`)
+			})
+
+			if err := tc.call(session); err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(query, tc.query) {
+				t.Errorf("expected query %v, got %v", tc.query, query)
+			}
+		})
+	}
+}
+
+func TestOrderRequestsUseAccountPaths(t *testing.T) {
+	var log requestLog
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		fmt.Fprint(w, `{"data":{"order":{"id":"42","status":"Received"}}}`)
+	})
+
+	order := &Order{
+		TimeInForce: Day,
+		OrderType:   Limit,
+		Price:       5,
+		PriceEffect: Debit,
+		Legs:        []*Leg{{InstrumentType: Equity, Symbol: "SPY", Quantity: 1, Action: BuyToOpen}},
+	}
+
+	if _, err := session.SubmitOrder("5WT0001", order); err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+
+	if _, err := session.DeleteOrder("5WT0001", "42"); err != nil {
+		t.Fatalf("DeleteOrder: %v", err)
+	}
+
+	want := []string{"POST /accounts/5WT0001/orders", "DELETE /accounts/5WT0001/orders/42"}
+	if !reflect.DeepEqual(log.all(), want) {
+		t.Errorf("expected %v, got %v", want, log.all())
+	}
+}
+
+func TestListEndpointsParseItems(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accounts/5WT0001/positions":
+			fmt.Fprint(w, `{"data":{"items":[{"account-number":"5WT0001","symbol":"SPY","instrument-type":"Equity",
+				"quantity":"10","quantity-direction":"Long","close-price":"450.5","multiplier":"1",
+				"updated-at":"2024-01-02T15:00:00Z"}]}}`)
+		case "/accounts/5WT0001/transactions":
+			fmt.Fprint(w, `{"data":{"items":[{"id":12,"account-number":"5WT0001","symbol":"SPY","instrument-type":"Equity",
+				"action":"Buy to Open","quantity":"10","price":"450.5","value":"4505.0","value-effect":"Debit",
+				"order-id":42,"transaction-date":"2024-01-02","lots":[{"id":"7","quantity":"10","price":"450.5"}]}]},
+				"pagination":{"per-page":250,"page-offset":0,"item-offset":0,"total-items":1,"total-pages":1,"current-item-count":1}}`)
+		case "/accounts/5WT0001/orders":
+			fmt.Fprint(w, `{"data":{"items":[{"id":42,"account-number":"5WT0001","status":"Filled","order-type":"Limit",
+				"price":"450.5","price-effect":"Debit","underlying-symbol":"SPY",
+				"legs":[{"symbol":"SPY","instrument-type":"Equity","action":"Buy to Open","quantity":"10"}]}]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	positions, err := session.Positions("5WT0001")
+	if err != nil {
+		t.Fatalf("Positions: %v", err)
+	}
+	if len(positions) != 1 || positions[0].Symbol != "SPY" || positions[0].Quantity != 10 || positions[0].ClosePrice != 450.5 ||
+		!positions[0].UpdatedAt.Equal(time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected positions: %+v", positions)
+	}
+
+	transactions, pagination, err := session.Transactions("5WT0001")
+	if err != nil {
+		t.Fatalf("Transactions: %v", err)
+	}
+	if len(transactions) != 1 || transactions[0].ID != 12 || transactions[0].OrderID != 42 || transactions[0].Action != BuyToOpen ||
+		transactions[0].ValueEffect != Debit || len(transactions[0].Lots) != 1 || transactions[0].Lots[0].Quantity != 10 ||
+		!transactions[0].TransactionDate.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected transactions: %+v", transactions)
+	}
+	if want := (&Pagination{PerPage: 250, TotalItems: 1, TotalPages: 1, CurrentItemCount: 1}); !reflect.DeepEqual(pagination, want) {
+		t.Errorf("expected pagination %+v, got %+v", want, pagination)
+	}
+
+	orders, pagination, err := session.Orders("5WT0001")
+	if err != nil {
+		t.Fatalf("Orders: %v", err)
+	}
+	if len(orders) != 1 || orders[0].ID != "42" || orders[0].Status != "Filled" || orders[0].Price != 450.5 ||
+		len(orders[0].Legs) != 1 || orders[0].Legs[0].Symbol != "SPY" {
+		t.Errorf("unexpected orders: %+v", orders)
+	}
+	if pagination != nil {
+		t.Errorf("expected no pagination, got %+v", pagination)
+	}
+}
+
+func TestListEndpointsHandleEmptyAndFailedResponses(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/accounts/5WT0001/"):
+			fmt.Fprint(w, `{"data":{"items":[]}}`)
+		case strings.HasPrefix(r.URL.Path, "/accounts/MISSING/"):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	list := map[string]func(accountNumber string) (int, error){
+		"positions": func(accountNumber string) (int, error) {
+			items, err := session.Positions(accountNumber)
+			return len(items), err
+		},
+		"transactions": func(accountNumber string) (int, error) {
+			items, _, err := session.Transactions(accountNumber)
+			return len(items), err
+		},
+		"orders": func(accountNumber string) (int, error) {
+			items, _, err := session.Orders(accountNumber)
+			return len(items), err
+		},
+	}
+
+	for name, fn := range list {
+		t.Run(name, func(t *testing.T) {
+			if count, err := fn("5WT0001"); err != nil || count != 0 {
+				t.Errorf("expected no items and no error, got %d, %v", count, err)
+			}
+
+			if _, err := fn("MISSING"); !errors.Is(err, ErrAccountNotFound) || !errors.Is(err, ErrInvalidHTTPResponse) {
+				t.Errorf("expected ErrAccountNotFound, got %v", err)
+			}
+
+			_, err := fn("BROKEN")
+			if !errors.Is(err, ErrInvalidHTTPResponse) || errors.Is(err, ErrAccountNotFound) || !strings.Contains(err.Error(), "("+name+")") {
+				t.Errorf("expected ErrInvalidHTTPResponse naming %s, got %v", name, err)
+			}
+		})
+	}
+}
+
+func TestTransactionsRejectsUnknownTypeBeforeRequesting(t *testing.T) {
+	var requests atomic.Int32
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fmt.Fprint(w, `{"data":{"items":[]}}`)
+	})
+
+	_, _, err := session.Transactions("5WT0001", TransactionFilterOpts{TransactionTypes: []string{"Trades"}})
+	if !errors.Is(err, ErrUnknownTransactionType) || !strings.Contains(err.Error(), `"Trades"`) {
+		t.Errorf("expected ErrUnknownTransactionType naming the type, got %v", err)
+	}
+
+	if requests.Load() != 0 {
+		t.Errorf("expected no requests, got %d", requests.Load())
+	}
+}
+
+func TestPositionsClosedWithin(t *testing.T) {
+	now := time.Now().UTC()
+	var query url.Values
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprintf(w, `{"data":{"items":[
+			{"symbol":"OPEN","quantity":"10","updated-at":%q},
+			{"symbol":"CLOSED-TODAY","quantity":"0","updated-at":%q},
+			{"symbol":"CLOSED-LAST-WEEK","quantity":"0","updated-at":%q}]}}`,
+			now.Add(-72*time.Hour).Format(time.RFC3339),
+			now.Add(-2*time.Hour).Format(time.RFC3339),
+			now.Add(-7*24*time.Hour).Format(time.RFC3339))
+	})
+
+	testCases := []struct {
+		name   string
+		filter PositionFilterOpts
+		want   []string
+	}{
+		{"1 day window", PositionFilterOpts{ClosedWithin: 24 * time.Hour}, []string{"OPEN", "CLOSED-TODAY"}},
+		{"all closed positions", PositionFilterOpts{ClosedWithin: 24 * time.Hour, IncludeClosedPositions: true}, []string{"OPEN", "CLOSED-TODAY", "CLOSED-LAST-WEEK"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			positions, err := session.Positions("5WT0001", tc.filter)
+			if err != nil {
+				t.Fatalf("Positions: %v", err)
+			}
+
+			if query.Get("include-closed-positions") != "true" {
+				t.Errorf("expected closed positions to be requested, got query %v", query)
+			}
+
+			symbols := make([]string, len(positions))
+			for idx, position := range positions {
+				symbols[idx] = position.Symbol
+			}
+
+			if !reflect.DeepEqual(symbols, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, symbols)
+			}
+		})
+	}
+}
+
+func TestTransactionsSortParam(t *testing.T) {
+	asc, desc := Asc, Desc
+	testCases := []struct {
+		name string
+		sort *SortDirection
+		want string
+	}{
+		{"default", nil, ""},
+		{"ascending", &asc, "asc"},
+		{"descending", &desc, "desc"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var query url.Values
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query()
+				fmt.Fprint(w, `{"data":{"items":[]}}`)
+			})
+
+			if _, _, err := session.Transactions("5WT0001", TransactionFilterOpts{Sort: tc.sort}); err != nil {
+				t.Fatalf("Transactions: %v", err)
+			}
+
+			if got := query.Get("sort"); got != tc.want || query.Has("sort") != (tc.want != "") {
+				t.Errorf("expected sort %q, got %v", tc.want, query)
+			}
+		})
+	}
+}
+
+func TestWithHeaderSharesTokenRefresh(t *testing.T) {
+	var exchanges atomic.Int32
+	var headers sync.Map
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sessions":
+			exchanges.Add(1)
+			fmt.Fprint(w, `{"data":{"session-token":"new-session-token","remember-token":"new-remember-token"}}`)
+		default:
+			headers.Store(r.Header.Get("X-Request-Source"), r.Header.Get("Authorization"))
+			fmt.Fprint(w, `{"data":{"items":[]}}`)
+		}
+	})
+	session.ExpiresOn = time.Now().Add(-time.Hour)
+	session.RememberToken.Store("remember-token")
+	session.RememberMeExpiresOn = time.Now().Add(time.Hour)
+
+	clone := session.WithHeader("X-Request-Source", "clone")
+
+	if _, err := clone.Accounts(); err != nil {
+		t.Fatalf("clone Accounts: %v", err)
+	}
+
+	if _, err := session.Accounts(); err != nil {
+		t.Fatalf("session Accounts: %v", err)
+	}
+
+	if n := exchanges.Load(); n != 1 {
+		t.Errorf("expected the remember token to be exchanged once, got %d", n)
+	}
+
+	if !session.Status().TokenValid || !clone.Status().TokenValid {
+		t.Errorf("expected both sessions to report a valid token: %+v, %+v", session.Status(), clone.Status())
+	}
+
+	for _, source := range []string{"clone", ""} {
+		if auth, _ := headers.Load(source); auth != "new-session-token" {
+			t.Errorf("expected request from %q to use the refreshed token, got %v", source, auth)
+		}
+	}
+}
+
+func TestCheckDayTrade(t *testing.T) {
+	testCases := []struct {
+		name            string
+		dayTradingPower string
+		change          string
+		effect          string
+		wantCost        float64
+		wantCanDayTrade bool
+	}{
+		{"sufficient", "10000.0", "4500.0", "Debit", 4500, true},
+		{"insufficient", "1000.0", "4500.0", "Debit", 4500, false},
+		{"releases buying power", "0.0", "4500.0", "Credit", 0, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var log requestLog
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				log.record(r)
+				switch r.URL.Path {
+				case "/accounts/5WT0001/balances":
+					fmt.Fprintf(w, `{"data":{"account-number":"5WT0001","day-trading-buying-power":%q}}`, tc.dayTradingPower)
+				case "/accounts/5WT0001/orders/dry-run":
+					fmt.Fprintf(w, `{"data":{"order":{"status":"Received"},"buying-power-effect":{
+						"change-in-buying-power":%q,"change-in-buying-power-effect":%q}}}`, tc.change, tc.effect)
+				default:
+					http.NotFound(w, r)
+				}
+			})
+
+			order := &Order{
+				TimeInForce: Day,
+				OrderType:   Limit,
+				Price:       450,
+				PriceEffect: Debit,
+				Legs:        []*Leg{{InstrumentType: Equity, Symbol: "SPY", Quantity: 10, Action: BuyToOpen}},
+			}
+
+			check, err := session.CheckDayTrade("5WT0001", order)
+			if err != nil {
+				t.Fatalf("CheckDayTrade: %v", err)
+			}
+
+			if check.OrderCost != tc.wantCost || check.CanDayTrade != tc.wantCanDayTrade {
+				t.Errorf("expected cost %v and CanDayTrade %v, got %+v", tc.wantCost, tc.wantCanDayTrade, check)
+			}
+
+			if want := []string{"GET /accounts/5WT0001/balances", "POST /accounts/5WT0001/orders/dry-run"}; !reflect.DeepEqual(log.all(), want) {
+				t.Errorf("expected %v, got %v", want, log.all())
+			}
+		})
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	testCases := []struct {
+		failures int
+		maxWait  time.Duration
+		want     time.Duration
+	}{
+		{0, time.Minute, time.Second},
+		{1, time.Minute, 2 * time.Second},
+		{3, time.Minute, 8 * time.Second},
+		{6, time.Minute, time.Minute},
+		{63, time.Minute, time.Minute},
+		{1000, time.Minute, time.Minute},
+		{1000, time.Duration(math.MaxInt64), time.Duration(math.MaxInt64)},
+	}
+
+	for _, tc := range testCases {
+		if got := backoff(time.Second, tc.failures, tc.maxWait); got != tc.want {
+			t.Errorf("backoff(1s, %d, %s) = %s, want %s", tc.failures, tc.maxWait, got, tc.want)
+		}
+	}
+}
+
+func TestEndpointRoute(t *testing.T) {
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{"https://api.tastyworks.com/accounts/5WT0001/orders/42?x=1", "/accounts/{account}/orders/{id}"},
+		{"https://api.tastyworks.com/accounts/5WT0001/orders", "/accounts/{account}/orders"},
+		{"https://api.tastyworks.com/accounts/5WT0001/orders/live", "/accounts/{account}/orders/live"},
+		{"https://api.tastyworks.com/accounts/5WT0001/orders/dry-run", "/accounts/{account}/orders/dry-run"},
+		{"https://api.tastyworks.com/accounts/5WT0001/positions", "/accounts/{account}/positions"},
+		{"https://api.tastyworks.com/customers/me/accounts/5WT0001", "/customers/me/accounts/{account}"},
+		{"https://api.tastyworks.com/customers/me/accounts", "/customers/{customer}/accounts"},
+		{"https://api.tastyworks.com/instruments/cryptocurrencies/BTC%2FUSD", "/instruments/cryptocurrencies/{symbol}"},
+		{"https://api.tastyworks.com/instruments/futures/%2FESZ4", "/instruments/futures/{symbol}"},
+		{"https://api.tastyworks.com/instruments/equities?symbol[]=SPY", "/instruments/equities"},
+		{"https://api.tastyworks.com/sessions", "/sessions"},
+		{"https://api.tastyworks.com/customers/me", "/customers/me"},
+	}
+
+	for _, tc := range testCases {
+		if got := endpointRoute(tc.url); got != tc.want {
+			t.Errorf("endpointRoute(%q) = %q, want %q", tc.url, got, tc.want)
+		}
+	}
+}
+
+// recordingObserver records the endpoints reported to OnRequestEnd
+type recordingObserver struct {
+	requestLog
+}
+
+func (observer *recordingObserver) OnRequestStart(string) {}
+
+func (observer *recordingObserver) OnRequestEnd(endpoint string, _ time.Duration, _ int, _ error) {
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	observer.requests = append(observer.requests, endpoint)
+}
+
+func TestObserverReceivesRouteTemplate(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"id":42,"status":"Live"}}`)
+	})
+
+	observer := &recordingObserver{}
+	session.Observer = observer
+
+	for _, orderID := range []string{"42", "43"} {
+		if _, err := session.GetOrder("5WT0001", orderID); err != nil {
+			t.Fatalf("GetOrder: %v", err)
+		}
+	}
+
+	want := []string{"/accounts/{account}/orders/{id}", "/accounts/{account}/orders/{id}"}
+	if !reflect.DeepEqual(observer.all(), want) {
+		t.Errorf("expected %v, got %v", want, observer.all())
+	}
+}
+
+func TestMarketDataMixedQuery(t *testing.T) {
+	var query url.Values
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, `{"data":{"items":[]}}`)
+	})
+
+	_, err := session.MarketDataMixed(map[string]InstrumentTypeChoice{
+		"SPY":                   Equity,
+		"AAPL":                  Equity,
+		"AAPL  240119C00190000": EquityOption,
+		"/ESZ4":                 Future,
+	})
+	if err != nil {
+		t.Fatalf("MarketDataMixed: %v", err)
+	}
+
+	want := url.Values{
+		"equity[]":        {"AAPL", "SPY"},
+		"equity-option[]": {"AAPL  240119C00190000"},
+		"future[]":        {"/ESZ4"},
+	}
+	if !reflect.DeepEqual(query, want) {
+		t.Errorf("expected query %v, got %v", want, query)
+	}
+}
+
+func TestResolveInstrumentTypes(t *testing.T) {
+	var query url.Values
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/instruments/equities" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		query = r.URL.Query()
+		fmt.Fprint(w, `{"data":{"items":[{"symbol":"SPY"},{"symbol":"BRK/B"}]}}`)
+	})
+
+	resolved, err := session.ResolveInstrumentTypes("SPY", "SPY   240119C00450000", "/ESZ4", "./ESZ4 EW4Z4 241129C5000", "BTC/USD", "BRK/B", "NOTREAL")
+	if err != nil {
+		t.Fatalf("ResolveInstrumentTypes: %v", err)
+	}
+
+	want := map[string]InstrumentTypeChoice{
+		"SPY":                      Equity,
+		"SPY   240119C00450000":    EquityOption,
+		"/ESZ4":                    Future,
+		"./ESZ4 EW4Z4 241129C5000": FutureOption,
+		"BTC/USD":                  Cryptocurrency,
+		"BRK/B":                    Equity,
+		"NOTREAL":                  UndefinedInstrument,
+	}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Errorf("expected %v, got %v", want, resolved)
+	}
+
+	if got := query["symbol[]"]; !reflect.DeepEqual(got, []string{"SPY", "BRK/B", "NOTREAL"}) {
+		t.Errorf("expected only unrecognized symbols to be looked up, got %v", got)
+	}
+}
+
+func TestGroupByComplexOrderOCO(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"items":[
+			{"id":1,"status":"Live","order-type":"Limit","complex-order-id":"77","complex-order-tag":"OCO::order",
+				"legs":[{"symbol":"SPY","instrument-type":"Equity","action":"Sell to Close","quantity":"10"}]},
+			{"id":2,"status":"Live","order-type":"Stop","complex-order-id":"77","complex-order-tag":"OCO::order",
+				"legs":[{"symbol":"SPY","instrument-type":"Equity","action":"Sell to Close","quantity":"10"}]},
+			{"id":3,"status":"Live","order-type":"Limit",
+				"legs":[
+					{"symbol":"SPY   240119C00450000","instrument-type":"Equity Option","action":"Sell to Open","quantity":"1","price":"3.1"},
+					{"symbol":"SPY   240119C00455000","instrument-type":"Equity Option","action":"Buy to Open","quantity":"1","price":"1.6"}]}]}}`)
+	})
+
+	orders, _, err := session.Orders("5WT0001")
+	if err != nil {
+		t.Fatalf("Orders: %v", err)
+	}
+
+	groups := GroupByComplexOrder(orders)
+	if len(groups) != 1 || len(groups["77"]) != 2 || groups["77"][0].ID != "1" || groups["77"][1].ID != "2" {
+		t.Fatalf("expected orders 1 and 2 grouped under complex order 77, got %v", groups)
+	}
+
+	for _, order := range groups["77"] {
+		if order.ComplexOrderTag != "OCO::order" {
+			t.Errorf("expected OCO tag on order %s, got %q", order.ID, order.ComplexOrderTag)
+		}
+	}
+
+	if legs := orders[2].Legs; legs[0].Price != 3.1 || legs[1].Price != 1.6 {
+		t.Errorf("expected leg prices to be parsed, got %v and %v", legs[0].Price, legs[1].Price)
+	}
+}
+
+// auditLog records the order events reported to an OrderAuditor
+type auditLog struct {
+	mu     sync.Mutex
+	events []*OrderEvent
+}
+
+func (audit *auditLog) OnOrderEvent(event *OrderEvent) {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	audit.events = append(audit.events, event)
+}
+
+func TestDeleteOrderAlreadyFilled(t *testing.T) {
+	testCases := []struct {
+		name           string
+		ignoreTerminal bool
+		orderStatus    string
+		wantErr        bool
+	}{
+		{"ignore terminal", true, "Filled", false},
+		{"ignore terminal but order is live", true, "Live", true},
+		{"report error", false, "Filled", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var log requestLog
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				log.record(r)
+				if r.Method == http.MethodDelete {
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					fmt.Fprint(w, `{"error":{"code":"order_not_cancellable"}}`)
+					return
+				}
+				fmt.Fprintf(w, `{"data":{"id":42,"status":%q}}`, tc.orderStatus)
+			})
+			audit := &auditLog{}
+			session.Auditor = audit
+
+			status, err := session.DeleteOrder("5WT0001", "42", DeleteOrderOpts{IgnoreTerminal: tc.ignoreTerminal})
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalidHTTPResponse) || status != nil {
+					t.Errorf("expected ErrInvalidHTTPResponse and no status, got %v, %v", status, err)
+				}
+			} else if err != nil || status == nil || status.Status != "Filled" {
+				t.Errorf("expected the filled status, got %v, %v", status, err)
+			}
+
+			want := []string{"DELETE /accounts/5WT0001/orders/42"}
+			if tc.ignoreTerminal {
+				want = append(want, "GET /accounts/5WT0001/orders/42")
+			}
+			if !reflect.DeepEqual(log.all(), want) {
+				t.Errorf("expected %v, got %v", want, log.all())
+			}
+
+			// the audit record matches what was returned to the caller
+			if len(audit.events) != 1 || audit.events[0].Status != status || !errors.Is(audit.events[0].Err, err) {
+				t.Errorf("unexpected audit events: %+v", audit.events)
+			}
+		})
+	}
+}
+
+func TestLastPrice(t *testing.T) {
+	testCases := []struct {
+		name           string
+		symbol         string
+		instrumentType InstrumentTypeChoice
+		response       string
+		wantQuery      url.Values
+		want           float64
+	}{
+		{
+			name:           "equity",
+			symbol:         "AAPL",
+			instrumentType: Equity,
+			response:       `{"data":{"items":[{"symbol":"AAPL","instrument-type":"Equity","last":"185.25","mark":"185.3"}]}}`,
+			wantQuery:      url.Values{"equity[]": {"AAPL"}},
+			want:           185.25,
+		},
+		{
+			name:           "untraded option uses mark",
+			symbol:         "AAPL  240119C00190000",
+			instrumentType: EquityOption,
+			response:       `{"data":{"items":[{"symbol":"AAPL  240119C00190000","instrument-type":"Equity Option","mark":"2.45"}]}}`,
+			wantQuery:      url.Values{"equity-option[]": {"AAPL  240119C00190000"}},
+			want:           2.45,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var query url.Values
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query()
+				fmt.Fprint(w, tc.response)
+			})
+
+			price, err := session.LastPrice(tc.symbol, tc.instrumentType)
+			if err != nil {
+				t.Fatalf("LastPrice: %v", err)
+			}
+
+			if price != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, price)
+			}
+
+			if !reflect.DeepEqual(query, tc.wantQuery) {
+				t.Errorf("expected query %v, got %v", tc.wantQuery, query)
+			}
+		})
+	}
+
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"items":[]}}`)
+	})
+	if _, err := session.LastPrice("AAPL", Equity); !errors.Is(err, ErrNoPriceAvailable) {
+		t.Errorf("expected ErrNoPriceAvailable, got %v", err)
+	}
+}
+
+func TestTransfers(t *testing.T) {
+	var query url.Values
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, `{"data":{"items":[
+			{"id":11,"account-number":"5WT0001","transaction-type":"Money Movement","transaction-sub-type":"Deposit",
+				"description":"ACH DEPOSIT","net-value":"5000.0","net-value-effect":"Credit",
+				"executed-at":"2024-01-05T15:00:00Z","transaction-date":"2024-01-05"},
+			{"id":12,"account-number":"5WT0001","transaction-type":"Money Movement","transaction-sub-type":"Withdrawal",
+				"description":"Wire Funds Disbursement","net-value":"1250.5","net-value-effect":"Debit",
+				"executed-at":"2024-01-20T16:30:00Z","transaction-date":"2024-01-20"},
+			{"id":13,"account-number":"5WT0001","transaction-type":"Money Movement","transaction-sub-type":"Deposit",
+				"executed-at":"2024-03-01T15:00:00Z"}
+		]},"pagination":{"per-page":250,"page-offset":0,"total-pages":1,"current-item-count":3}}`)
+	})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	transfers, err := session.Transfers("5WT0001", start, end)
+	if err != nil {
+		t.Fatalf("Transfers: %v", err)
+	}
+
+	if query.Get("type") != TransactionTypeMoneyMovement {
+		t.Errorf("expected to request money movement transactions, got %v", query)
+	}
+
+	want := []*Transfer{
+		{
+			ID: 11, AccountNumber: "5WT0001", TransferType: "Deposit", Description: "ACH DEPOSIT",
+			Amount: 5000, AmountEffect: Credit,
+			ExecutedAt:      time.Date(2024, 1, 5, 15, 0, 0, 0, time.UTC),
+			TransactionDate: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			ID: 12, AccountNumber: "5WT0001", TransferType: "Withdrawal", Description: "Wire Funds Disbursement",
+			Amount: 1250.5, AmountEffect: Debit,
+			ExecutedAt:      time.Date(2024, 1, 20, 16, 30, 0, 0, time.UTC),
+			TransactionDate: time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	if len(transfers) != len(want) {
+		t.Fatalf("expected %d transfers, got %d", len(want), len(transfers))
+	}
+
+	for idx := range want {
+		got, expected := transfers[idx], want[idx]
+		if got.ID != expected.ID || got.AccountNumber != expected.AccountNumber || got.TransferType != expected.TransferType ||
+			got.Description != expected.Description || got.Amount != expected.Amount || got.AmountEffect != expected.AmountEffect ||
+			!got.ExecutedAt.Equal(expected.ExecutedAt) || !got.TransactionDate.Equal(expected.TransactionDate) {
+			t.Errorf("transfer %d:\n got: %+v\nwant: %+v", idx, got, expected)
+		}
+	}
+}
+
+func TestSubmitContingentOrder(t *testing.T) {
+	var body string
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = string(data)
+		fmt.Fprint(w, `{"data":{"order":{"id":"42","status":"Contingent"}}}`)
+	})
+
+	order := &Order{
+		TimeInForce: Day,
+		OrderType:   Limit,
+		Price:       180,
+		PriceEffect: Debit,
+		Legs:        []*Leg{{InstrumentType: Equity, Symbol: "AAPL", Quantity: 10, Action: BuyToOpen}},
+		OrderRules: &Rules{
+			Conditions: []*Condition{
+				{Action: Route, Symbol: "AAPL", InstrumentType: Equity, Indicator: Last, Comparator: GTE, Threshold: 182.5},
+			},
+		},
+	}
+
+	if _, err := session.SubmitOrder("5WT0001", order); err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+
+	want := `"conditions":[{"action":"route","symbol":"AAPL","instrument-type":"Equity","indicator":"last","comparator":"gte","threshold":182.5}]`
+	if !strings.Contains(body, want) {
+		t.Errorf("expected request body to contain %s, got %s", want, body)
+	}
+}
+
+func TestRollCandidateForShortPut(t *testing.T) {
+	option := func(symbol, optionType, strike, expiration string, active bool) string {
+		return fmt.Sprintf(`{"symbol":%q,"underlying-symbol":"SPY","option-type":%q,"strike-price":%q,"expiration-date":%q,"active":%t}`,
+			symbol, optionType, strike, expiration, active)
+	}
+
+	var log requestLog
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		fmt.Fprintf(w, `{"data":{"items":[%s]}}`, strings.Join([]string{
+			option("SPY   240119P00450000", "P", "450.0", "2024-01-19", true),
+			option("SPY   240126P00450000", "P", "450.0", "2024-01-26", false),
+			option("SPY   240126P00445000", "P", "445.0", "2024-01-26", true),
+			option("SPY   240126P00449000", "P", "449.0", "2024-01-26", true),
+			option("SPY   240126P00455000", "P", "455.0", "2024-01-26", true),
+			option("SPY   240126C00450000", "C", "450.0", "2024-01-26", true),
+			option("SPY   240202P00450000", "P", "450.0", "2024-02-02", true),
+		}, ","))
+	})
+
+	chain, err := session.OptionChain("SPY")
+	if err != nil {
+		t.Fatalf("OptionChain: %v", err)
+	}
+
+	want := []string{"GET /option-chains/SPY"}
+	if !reflect.DeepEqual(log.all(), want) {
+		t.Errorf("expected %v, got %v", want, log.all())
+	}
+
+	candidate, err := RollCandidate(chain, "SPY   240119P00450000")
+	if err != nil {
+		t.Fatalf("RollCandidate: %v", err)
+	}
+
+	if candidate.Symbol != "SPY   240126P00449000" {
+		t.Errorf("expected to roll to SPY   240126P00449000, got %s", candidate.Symbol)
+	}
+
+	if _, err := RollCandidate(chain, "SPY   240202P00450000"); !errors.Is(err, ErrNoRollCandidate) {
+		t.Errorf("expected ErrNoRollCandidate for the last expiration, got %v", err)
+	}
+
+	if _, err := RollCandidate(chain, "SPY   240119P00400000"); !errors.Is(err, ErrNoRollCandidate) {
+		t.Errorf("expected ErrNoRollCandidate for an unknown symbol, got %v", err)
+	}
+}
+
+// compressSessionData compresses data the way Session.Marshal does
+func compressSessionData(t *testing.T, data string) []byte {
+	t.Helper()
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+
+	return encoder.EncodeAll([]byte(data), nil)
+}
+
+func TestNewSessionFromBytesWrapsDecodeErrors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		data       string
+		wantOffset bool
+	}{
+		{"truncated", `{"url":"https://api.cert.tastyworks.com","token":"abc","expires":17`, false},
+		{"malformed", `{"url":"https://api.cert.tastyworks.com","token":abc}`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSessionFromBytes(compressSessionData(t, tc.data))
+			if !errors.Is(err, ErrInvalidSessionData) {
+				t.Fatalf("expected ErrInvalidSessionData, got %v", err)
+			}
+
+			if tc.wantOffset && !strings.Contains(err.Error(), "at byte offset") {
+				t.Errorf("expected the error to include the byte offset, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDeltaByExpiration(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"items":[
+			{"symbol":"SPY   240119C00470000","instrument-type":"Equity Option","quantity":2,"quantity-direction":"Long","multiplier":100,"expires-at":"2024-01-19T21:15:00.000+00:00"},
+			{"symbol":"SPY   240119P00450000","instrument-type":"Equity Option","quantity":1,"quantity-direction":"Short","multiplier":100,"expires-at":"2024-01-19T21:15:00.000+00:00"},
+			{"symbol":"SPY   240216C00480000","instrument-type":"Equity Option","quantity":3,"quantity-direction":"Short","multiplier":100,"expires-at":"2024-02-16T21:15:00.000+00:00"},
+			{"symbol":"SPY","instrument-type":"Equity","quantity":100,"quantity-direction":"Long","multiplier":1}
+		]}}`)
+	})
+
+	greeks := map[string]float64{
+		"SPY   240119C00470000": 0.5,
+		"SPY   240119P00450000": -0.3,
+		"SPY   240216C00480000": 0.25,
+	}
+
+	exposure, err := session.DeltaByExpiration("5WT0001", greeks)
+	if err != nil {
+		t.Fatalf("DeltaByExpiration: %v", err)
+	}
+
+	want := map[time.Time]float64{
+		time.Date(2024, 1, 19, 0, 0, 0, 0, time.UTC): 130,
+		time.Date(2024, 2, 16, 0, 0, 0, 0, time.UTC): -75,
+	}
+	if len(exposure) != len(want) {
+		t.Fatalf("expected %v, got %v", want, exposure)
+	}
+
+	for expiration, delta := range want {
+		if math.Abs(exposure[expiration]-delta) > 1e-9 {
+			t.Errorf("expected delta %v on %s, got %v", delta, expiration.Format(time.DateOnly), exposure[expiration])
+		}
+	}
+
+	delete(greeks, "SPY   240216C00480000")
+	if _, err := session.DeltaByExpiration("5WT0001", greeks); !errors.Is(err, ErrNoGreeksAvailable) {
+		t.Errorf("expected ErrNoGreeksAvailable, got %v", err)
+	}
+}
+
+func TestMultiplierIsCached(t *testing.T) {
+	multiplierCache.Range(func(key, _ any) bool {
+		multiplierCache.Delete(key)
+		return true
+	})
+
+	var log requestLog
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		switch r.URL.Path {
+		case "/instruments/equity-options/QQQ   240119C00400000":
+			fmt.Fprint(w, `{"data":{"symbol":"QQQ   240119C00400000","shares-per-contract":100}}`)
+		case "/instruments/futures//ESH4":
+			fmt.Fprint(w, `{"data":{"symbol":"/ESH4","notional-multiplier":"50.0"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	testCases := []struct {
+		symbol         string
+		instrumentType InstrumentTypeChoice
+		want           float64
+	}{
+		{"QQQ   240119C00400000", EquityOption, 100},
+		{"/ESH4", Future, 50},
+		{"QQQ", Equity, 1},
+	}
+
+	// the second round is served from the cache
+	for round := 0; round < 2; round++ {
+		for _, tc := range testCases {
+			multiplier, err := session.Multiplier(tc.symbol, tc.instrumentType)
+			if err != nil {
+				t.Fatalf("Multiplier(%s): %v", tc.symbol, err)
+			}
+
+			if multiplier != tc.want {
+				t.Errorf("expected multiplier %v for %s, got %v", tc.want, tc.symbol, multiplier)
+			}
+		}
+	}
+
+	want := []string{"GET /instruments/equity-options/QQQ   240119C00400000", "GET /instruments/futures//ESH4"}
+	if !reflect.DeepEqual(log.all(), want) {
+		t.Errorf("expected %v, got %v", want, log.all())
+	}
+}
+
+func TestReauthenticateRestoredSession(t *testing.T) {
+	var logins atomic.Int32
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/sessions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		logins.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"login":"trader"`) || !strings.Contains(string(body), `"password":"secret"`) {
+			t.Errorf("unexpected login body: %s", body)
+		}
+		fmt.Fprint(w, `{"data":{"session-token":"new-session-token","remember-token":"new-remember-token"}}`)
+	})
+	session.Username = "trader"
+	session.ExpiresOn = time.Now().Add(-time.Hour)
+	session.RememberToken.Store("old-remember-token")
+	session.RememberMeExpiresOn = time.Now().Add(-time.Hour)
+
+	data, err := session.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	restored, err := NewSessionFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewSessionFromBytes: %v", err)
+	}
+	restored.BaseURL = session.BaseURL
+
+	if _, err := restored.AuthorizationHeader(); !errors.Is(err, ErrRememberTokenExpired) {
+		t.Fatalf("expected ErrRememberTokenExpired before reauthenticating, got %v", err)
+	}
+
+	if err := restored.Reauthenticate("secret"); err != nil {
+		t.Fatalf("Reauthenticate: %v", err)
+	}
+
+	header, err := restored.AuthorizationHeader()
+	if err != nil {
+		t.Fatalf("AuthorizationHeader: %v", err)
+	}
+
+	if header != "new-session-token" || loadToken(restored.RememberToken) != "new-remember-token" {
+		t.Errorf("expected new tokens, got %q and %q", header, loadToken(restored.RememberToken))
+	}
+
+	if logins.Load() != 1 {
+		t.Errorf("expected 1 login, got %d", logins.Load())
+	}
+}
+
+func TestAuditorCapturesSubmitAndCancel(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			fmt.Fprint(w, `{"data":{"order":{"id":"42","status":"Received"},"warnings":[{"code":"w","message":"warning"}]}}`)
+		case http.MethodDelete:
+			fmt.Fprint(w, `{"data":{"order":{"id":"42","status":"Cancel Requested"}}}`)
+		}
+	})
+	audit := &auditLog{}
+	session.Auditor = audit
+
+	order := &Order{
+		TimeInForce: Day,
+		OrderType:   Limit,
+		Price:       5,
+		PriceEffect: Debit,
+		Legs:        []*Leg{{InstrumentType: Equity, Symbol: "SPY", Quantity: 1, Action: BuyToOpen}},
+	}
+
+	if _, err := session.SubmitOrder("5WT0001", order); err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+
+	if _, err := session.DeleteOrder("5WT0001", "42"); err != nil {
+		t.Fatalf("DeleteOrder: %v", err)
+	}
+
+	if len(audit.events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(audit.events))
+	}
+
+	submitted, cancelled := audit.events[0], audit.events[1]
+	if submitted.Action != OrderSubmitted || submitted.AccountNumber != "5WT0001" || submitted.Order != order ||
+		submitted.Status == nil || submitted.Status.ID != "42" || len(submitted.Warnings) != 1 || submitted.Err != nil {
+		t.Errorf("unexpected submit event: %+v", submitted)
+	}
+
+	if cancelled.Action != OrderCancelled || cancelled.AccountNumber != "5WT0001" || cancelled.OrderID != "42" ||
+		cancelled.Order != nil || cancelled.Status == nil || cancelled.Status.Status != "Cancel Requested" || cancelled.Err != nil {
+		t.Errorf("unexpected cancel event: %+v", cancelled)
+	}
+
+	for _, event := range audit.events {
+		if event.Time.IsZero() {
+			t.Errorf("expected %s event to be timestamped", event.Action)
+		}
+	}
+}
+
+func TestSubmitNotionalThresholdOrder(t *testing.T) {
+	var body string
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = string(data)
+		fmt.Fprint(w, `{"data":{"order":{"id":"42","status":"Contingent","order-rule":{"conditions":[
+			{"id":"7","action":"route","symbol":"AAPL","instrument-type":"Equity","indicator":"last","comparator":"gte",
+				"threshold":"50000.0","is-threshold-based-on-notional":true}]}}}}`)
+	})
+
+	order := &Order{
+		TimeInForce: Day,
+		OrderType:   Market,
+		Legs:        []*Leg{{InstrumentType: Equity, Symbol: "AAPL", Quantity: 250, Action: SellToClose}},
+		OrderRules: &Rules{
+			Conditions: []*Condition{
+				{
+					Action: Route, Symbol: "AAPL", InstrumentType: Equity, Indicator: Last, Comparator: GTE, Threshold: 50000,
+					IsThresholdBasedOnNotional: true,
+				},
+			},
+		},
+	}
+
+	resp, err := session.SubmitOrder("5WT0001", order)
+	if err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+
+	if want := `"threshold":50000,"is-threshold-based-on-notional":true`; !strings.Contains(body, want) {
+		t.Errorf("expected request body to contain %s, got %s", want, body)
+	}
+
+	if len(resp.Order.OrderRule) != 1 || len(resp.Order.OrderRule[0].Conditions) != 1 ||
+		!resp.Order.OrderRule[0].Conditions[0].IsThresholdBasedOnNotional {
+		t.Errorf("expected the returned condition to be notional, got %+v", resp.Order.OrderRule)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	oversized := `{"data":{"id":"42","status":"Live","padding":"` + strings.Repeat("x", 4096) + `"}}`
+
+	testCases := []struct {
+		name    string
+		body    string
+		chunked bool
+		wantErr error
+	}{
+		{"within limit", `{"data":{"id":"42","status":"Live"}}`, false, nil},
+		{"oversized with content length", oversized, false, ErrResponseTooLarge},
+		{"oversized without content length", oversized, true, ErrResponseTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				if tc.chunked {
+					// flushing before writing the body omits the Content-Length header
+					w.(http.Flusher).Flush()
+				}
+				fmt.Fprint(w, tc.body)
+			})
+			session.MaxResponseBytes = 1024
+
+			_, err := session.GetOrder("5WT0001", "42")
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMarketableLimitOrder(t *testing.T) {
+	var body string
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = string(data)
+
+		// echo the submitted order back as its status
+		fmt.Fprintf(w, `{"data":{"order":%s}}`, strings.Replace(body, "{", `{"id":"42","status":"Received",`, 1))
+	})
+
+	order := NewMarketableLimitOrder("AAPL", Equity, SellToClose, 10, 185.5)
+	if order.PriceEffect != Credit {
+		t.Errorf("expected a sell to be a credit, got %s", order.PriceEffect)
+	}
+
+	resp, err := session.SubmitOrder("5WT0001", order)
+	if err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+
+	if !strings.Contains(body, `"order-type":"Marketable Limit"`) {
+		t.Errorf("expected order type Marketable Limit, got %s", body)
+	}
+
+	if resp.Order.OrderType != MarketableLimit || resp.Order.Price != 185.5 {
+		t.Errorf("expected a marketable limit order at 185.5, got %s at %v", resp.Order.OrderType, resp.Order.Price)
+	}
+
+	if buy := NewMarketableLimitOrder("AAPL", Equity, BuyToOpen, 10, 185.5); buy.PriceEffect != Debit {
+		t.Errorf("expected a buy to be a debit, got %s", buy.PriceEffect)
+	}
+
+	unpriced := NewMarketableLimitOrder("AAPL", Equity, BuyToOpen, 10, 0)
+	if _, err := session.SubmitOrder("5WT0001", unpriced); !errors.Is(err, ErrInvalidOrder) {
+		t.Errorf("expected ErrInvalidOrder without a price, got %v", err)
+	}
+}
+
+func TestSessionStatus(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name           string
+		expiresOn      time.Time
+		rememberToken  string
+		rememberExpiry time.Time
+		oauthToken     string
+		wantValid      bool
+		wantRefresh    bool
+	}{
+		{"valid without refresh", now.Add(time.Hour), "", time.Time{}, "", true, false},
+		{"valid with remember token", now.Add(time.Hour), "remember", now.Add(24 * time.Hour), "", true, true},
+		{"expired with remember token", now.Add(-time.Hour), "remember", now.Add(24 * time.Hour), "", false, true},
+		{"expired remember token", now.Add(-time.Hour), "remember", now.Add(-time.Minute), "", false, false},
+		{"expired with OAuth", now.Add(-time.Hour), "", time.Time{}, "refresh", false, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token := &atomic.Value{}
+			token.Store("session-token")
+			rememberToken := &atomic.Value{}
+			rememberToken.Store(tc.rememberToken)
+
+			session := &Session{
+				ExpiresOn:           tc.expiresOn,
+				RememberMeExpiresOn: tc.rememberExpiry,
+				OAuthRefreshToken:   tc.oauthToken,
+				Token:               token,
+				RememberToken:       rememberToken,
+				RefreshLocker:       &sync.Mutex{},
+			}
+
+			status := session.Status()
+			if status.TokenValid != tc.wantValid || status.CanRefresh != tc.wantRefresh {
+				t.Errorf("expected valid %v and refresh %v, got %v and %v", tc.wantValid, tc.wantRefresh, status.TokenValid, status.CanRefresh)
+			}
+
+			if !status.TokenExpiresOn.Equal(tc.expiresOn) || (status.TokenExpiresIn > 0) != tc.wantValid {
+				t.Errorf("unexpected token expiry %s (in %s)", status.TokenExpiresOn, status.TokenExpiresIn)
+			}
+
+			if !status.RememberTokenExpiresOn.Equal(tc.rememberExpiry) {
+				t.Errorf("expected remember token expiry %s, got %s", tc.rememberExpiry, status.RememberTokenExpiresOn)
+			}
+
+			if withHeader := session.WithHeader("X-Test", "1").Status(); withHeader.CanRefresh != tc.wantRefresh || withHeader.TokenValid != tc.wantValid {
+				t.Errorf("expected a WithHeader copy to report the original session's status, got %+v", withHeader)
+			}
+		})
+	}
+}
+
+func TestSetAccountNickname(t *testing.T) {
+	var log requestLog
+	var body string
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		fmt.Fprint(w, `{"data":{"account-number":"5WT0001","nickname":"Retirement"}}`)
+	})
+
+	if err := session.SetAccountNickname("5WT0001", "Retirement"); err != nil {
+		t.Fatalf("SetAccountNickname: %v", err)
+	}
+
+	want := []string{"PATCH /customers/me/accounts/5WT0001"}
+	if !reflect.DeepEqual(log.all(), want) {
+		t.Errorf("expected %v, got %v", want, log.all())
+	}
+
+	if body != `{"nickname":"Retirement"}` {
+		t.Errorf("unexpected request body: %s", body)
+	}
+}
+
+func TestFutureStreamerSymbol(t *testing.T) {
+	streamerSymbolCache.Delete("/ESZ4")
+
+	var log requestLog
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		fmt.Fprint(w, `{"data":{"symbol":"/ESZ4","product-code":"ES","streamer-symbol":"/ESZ24:XCME"}}`)
+	})
+
+	// the second lookup is served from the cache
+	for idx := 0; idx < 2; idx++ {
+		streamerSymbol, err := session.FutureStreamerSymbol("/ESZ4")
+		if err != nil {
+			t.Fatalf("FutureStreamerSymbol: %v", err)
+		}
+
+		if streamerSymbol != "/ESZ24:XCME" {
+			t.Errorf("expected /ESZ24:XCME, got %s", streamerSymbol)
+		}
+	}
+
+	want := []string{"GET /instruments/futures//ESZ4"}
+	if !reflect.DeepEqual(log.all(), want) {
+		t.Errorf("expected %v, got %v", want, log.all())
+	}
+}
+
+func TestPositionsParseUnderlyingProductCode(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"items":[
+			{"symbol":"/ESZ4","instrument-type":"Future","underlying-symbol":"/ESZ4","underlying-product-code":"ES","quantity":1,"quantity-direction":"Long","multiplier":50},
+			{"symbol":"AAPL","instrument-type":"Equity","underlying-symbol":"AAPL","quantity":10,"quantity-direction":"Long","multiplier":1}
+		]}}`)
+	})
+
+	positions, err := session.Positions("5WT0001")
+	if err != nil {
+		t.Fatalf("Positions: %v", err)
+	}
+
+	if len(positions) != 2 {
+		t.Fatalf("expected 2 positions, got %d", len(positions))
+	}
+
+	if positions[0].UnderlyingProductCode != "ES" {
+		t.Errorf("expected product code ES, got %q", positions[0].UnderlyingProductCode)
+	}
+
+	if positions[1].UnderlyingProductCode != "" {
+		t.Errorf("expected no product code for an equity, got %q", positions[1].UnderlyingProductCode)
+	}
+}
+
+func TestMarketDataMixedSnapshots(t *testing.T) {
+	var requests atomic.Int32
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fmt.Fprint(w, `{"data":{"items":[
+			{"symbol":"AAPL","instrument-type":"Equity","bid":"185.1","ask":"185.2","mark":"185.15","last":"185.12","volume":"51234567"},
+			{"symbol":"AAPL  240119C00190000","instrument-type":"Equity Option","bid":"2.4","ask":"2.5","mark":"2.45"},
+			{"symbol":"SPY","instrument-type":"Equity","bid":"470.5","ask":"470.6","mark":"470.55","last":"470.52"}
+		]}}`)
+	})
+
+	snapshots, err := session.MarketDataMixed(map[string]InstrumentTypeChoice{
+		"AAPL":                  Equity,
+		"SPY":                   Equity,
+		"AAPL  240119C00190000": EquityOption,
+	})
+	if err != nil {
+		t.Fatalf("MarketDataMixed: %v", err)
+	}
+
+	if requests.Load() != 1 {
+		t.Errorf("expected mixed instrument types in a single request, got %d requests", requests.Load())
+	}
+
+	got := make(map[string]*MarketDataSnapshot, len(snapshots))
+	for _, snapshot := range snapshots {
+		got[snapshot.Symbol] = snapshot
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("expected 3 snapshots, got %d", len(got))
+	}
+
+	if snapshot := got["AAPL"]; snapshot.InstrumentType != Equity || snapshot.Last != 185.12 || snapshot.Volume != 51234567 {
+		t.Errorf("unexpected AAPL snapshot: %+v", snapshot)
+	}
+
+	if snapshot := got["AAPL  240119C00190000"]; snapshot.InstrumentType != EquityOption || snapshot.Mark != 2.45 || snapshot.Last != 0 {
+		t.Errorf("unexpected option snapshot: %+v", snapshot)
+	}
+
+	if _, err := session.MarketDataMixed(map[string]InstrumentTypeChoice{"XYZ": UndefinedInstrument}); !errors.Is(err, ErrUnsupportedInstrumentType) {
+		t.Errorf("expected ErrUnsupportedInstrumentType, got %v", err)
+	}
+}
+
+func TestBalanceSnapshotsOverRange(t *testing.T) {
+	pages := []string{
+		`{"data":{"items":[
+			{"account-number":"5WT0001","net-liquidating-value":"10000.0","snapshot-date":"2024-01-02"},
+			{"account-number":"5WT0001","net-liquidating-value":"10150.5","snapshot-date":"2024-01-03"}
+		]},"pagination":{"per-page":2,"page-offset":0,"total-pages":2}}`,
+		`{"data":{"items":[
+			{"account-number":"5WT0001","net-liquidating-value":"9980.25","snapshot-date":"2024-01-04"}
+		]},"pagination":{"per-page":2,"page-offset":1,"total-pages":2}}`,
+	}
+
+	var queries []url.Values
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/accounts/5WT0001/balance-snapshots" {
+			http.NotFound(w, r)
+			return
+		}
+
+		queries = append(queries, r.URL.Query())
+		page := 0
+		fmt.Sscan(r.URL.Query().Get("page-offset"), &page)
+		fmt.Fprint(w, pages[page])
+	})
+
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)
+	balances, err := session.BalanceSnapshots("5WT0001", start, end, EOD)
+	if err != nil {
+		t.Fatalf("BalanceSnapshots: %v", err)
+	}
+
+	wantQuery := url.Values{"start-date": {"2024-01-02"}, "end-date": {"2024-01-04"}, "time-of-day": {"EOD"}, "page-offset": {"0"}}
+	if len(queries) != 2 || !reflect.DeepEqual(queries[0], wantQuery) || queries[1].Get("page-offset") != "1" {
+		t.Errorf("unexpected queries: %v", queries)
+	}
+
+	wantValues := []float64{10000, 10150.5, 9980.25}
+	if len(balances) != len(wantValues) {
+		t.Fatalf("expected %d snapshots, got %d", len(wantValues), len(balances))
+	}
+
+	for idx, balance := range balances {
+		day := start.AddDate(0, 0, idx)
+		if balance.NetLiquidatingValue != wantValues[idx] || !balance.SnapshotDate.Equal(day) {
+			t.Errorf("expected %v on %s, got %v on %s", wantValues[idx], day.Format(time.DateOnly), balance.NetLiquidatingValue, balance.SnapshotDate)
+		}
+	}
+}
+
+func TestOrderReplacementChain(t *testing.T) {
+	orders := map[string]string{
+		"1": `{"id":"1","status":"Cancelled","replacing-order-id":"2"}`,
+		"2": `{"id":"2","status":"Cancelled","replaces-order-id":"1","replacing-order-id":"3"}`,
+		"3": `{"id":"3","status":"Live","replaces-order-id":"2"}`,
+	}
+
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		order, ok := orders[strings.TrimPrefix(r.URL.Path, "/accounts/5WT0001/orders/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"data":%s}`, order)
+	})
+
+	// the chain is the same no matter which generation it starts from
+	for _, orderID := range []string{"1", "2", "3"} {
+		chain, err := session.OrderReplacementChain("5WT0001", orderID)
+		if err != nil {
+			t.Fatalf("OrderReplacementChain(%s): %v", orderID, err)
+		}
+
+		ids := make([]string, len(chain))
+		for idx, order := range chain {
+			ids[idx] = order.ID
+		}
+
+		if want := []string{"1", "2", "3"}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("expected chain %v starting from %s, got %v", want, orderID, ids)
+		}
+	}
+}
+
+func TestHasLiveOrders(t *testing.T) {
+	testCases := []struct {
+		name     string
+		response string
+		want     bool
+	}{
+		{"live orders", `{"data":{"items":[{"id":"1","status":"Live"}]},"pagination":{"per-page":1,"total-items":37}}`, true},
+		{"no live orders", `{"data":{"items":[]},"pagination":{"per-page":1,"total-items":0}}`, false},
+		{"no pagination", `{"data":{"items":[{"id":"1","status":"Routed"}]}}`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var log requestLog
+			var query url.Values
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				log.record(r)
+				query = r.URL.Query()
+				fmt.Fprint(w, tc.response)
+			})
+
+			hasLive, err := session.HasLiveOrders("5WT0001")
+			if err != nil {
+				t.Fatalf("HasLiveOrders: %v", err)
+			}
+
+			if hasLive != tc.want {
+				t.Errorf("expected %v, got %v", tc.want, hasLive)
+			}
+
+			want := []string{"GET /accounts/5WT0001/orders"}
+			if !reflect.DeepEqual(log.all(), want) {
+				t.Errorf("expected a single request %v, got %v", want, log.all())
+			}
+
+			if query.Get("per-page") != "1" || len(query["status[]"]) == 0 {
+				t.Errorf("expected a single working order to be requested, got %v", query)
+			}
+		})
+	}
+}
+
+func TestWaitForOrderLifecycle(t *testing.T) {
+	states := []string{"Received", "Routed", "In Flight", "Live", "Filled"}
+
+	var polls atomic.Int32
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		poll := int(polls.Add(1)) - 1
+		fmt.Fprintf(w, `{"data":{"id":"42","status":%q}}`, states[min(poll, len(states)-1)])
+	})
+
+	status, err := session.WaitForOrder(context.Background(), "5WT0001", "42", time.Millisecond)
+	if err != nil {
+		t.Fatalf("WaitForOrder: %v", err)
+	}
+
+	if status.Status != "Filled" {
+		t.Errorf("expected Filled, got %s", status.Status)
+	}
+
+	if polls.Load() != int32(len(states)) {
+		t.Errorf("expected %d polls, got %d", len(states), polls.Load())
+	}
+}
+
+func TestWaitForOrderReturnsLastStatusWhenCancelled(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"id":"42","status":"Live"}}`)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	status, err := session.WaitForOrder(ctx, "5WT0001", "42", time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if status == nil || status.Status != "Live" {
+		t.Errorf("expected the last observed status to be Live, got %+v", status)
+	}
+}
+
+func TestDayTradeCount(t *testing.T) {
+	var log requestLog
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		fmt.Fprint(w, `{"data":{"account-number":"5WT0001","day-trade-count":3,"is-pattern-day-trader":false,"pdt-reset-on":"2024-01-12"}}`)
+	})
+
+	count, err := session.DayTradeCount("5WT0001")
+	if err != nil {
+		t.Fatalf("DayTradeCount: %v", err)
+	}
+
+	if count != 3 {
+		t.Errorf("expected 3 day trades, got %d", count)
+	}
+
+	status, err := session.TradingStatus("5WT0001")
+	if err != nil {
+		t.Fatalf("TradingStatus: %v", err)
+	}
+
+	if status.DayTradeCount != 3 || status.IsPatternDayTrader || !status.PDTResetOn.Equal(time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected trading status: %+v", status)
+	}
+
+	want := []string{"GET /accounts/5WT0001/trading-status", "GET /accounts/5WT0001/trading-status"}
+	if !reflect.DeepEqual(log.all(), want) {
+		t.Errorf("expected %v, got %v", want, log.all())
+	}
+}
+
+func TestCommissionSummaryMixedTransactions(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"items":[
+			{"id":1,"transaction-type":"Trade","instrument-type":"Equity","executed-at":"2024-01-05T15:00:00Z",
+				"commission":"0.0","commission-effect":"None","clearing-fees":"0.08","clearing-fees-effect":"Debit",
+				"regulatory-fees":"0.02","regulatory-fees-effect":"Debit"},
+			{"id":2,"transaction-type":"Trade","instrument-type":"Equity Option","executed-at":"2024-01-08T15:00:00Z",
+				"commission":"1.0","commission-effect":"Debit","clearing-fees":"0.1","clearing-fees-effect":"Debit",
+				"regulatory-fees":"0.05","regulatory-fees-effect":"Debit",
+				"proprietary-index-option-fees":"0.65","proprietary-index-option-fees-effect":"Debit"},
+			{"id":3,"transaction-type":"Trade","instrument-type":"Equity Option","executed-at":"2024-01-09T15:00:00Z",
+				"commission":"0.5","commission-effect":"Credit"},
+			{"id":4,"transaction-type":"Trade","instrument-type":"Equity Option","executed-at":"2024-03-01T15:00:00Z",
+				"commission":"100.0","commission-effect":"Debit"}
+		]},"pagination":{"per-page":250,"page-offset":0,"total-pages":1,"current-item-count":4}}`)
+	})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	summary, err := session.CommissionSummary("5WT0001", start, end)
+	if err != nil {
+		t.Fatalf("CommissionSummary: %v", err)
+	}
+
+	equal := func(got, want *FeeTotals) bool {
+		return math.Abs(got.Commission-want.Commission) < 1e-9 &&
+			math.Abs(got.ClearingFees-want.ClearingFees) < 1e-9 &&
+			math.Abs(got.RegulatoryFees-want.RegulatoryFees) < 1e-9 &&
+			math.Abs(got.ProprietaryIndexOptionFees-want.ProprietaryIndexOptionFees) < 1e-9 &&
+			math.Abs(got.Total-want.Total) < 1e-9
+	}
+
+	want := map[InstrumentTypeChoice]*FeeTotals{
+		Equity:       {ClearingFees: 0.08, RegulatoryFees: 0.02, Total: 0.1},
+		EquityOption: {Commission: 0.5, ClearingFees: 0.1, RegulatoryFees: 0.05, ProprietaryIndexOptionFees: 0.65, Total: 1.3},
+	}
+
+	if len(summary.ByInstrumentType) != len(want) {
+		t.Fatalf("expected totals for %d instrument types, got %d", len(want), len(summary.ByInstrumentType))
+	}
+
+	for instrumentType, totals := range want {
+		if got := summary.ByInstrumentType[instrumentType]; got == nil || !equal(got, totals) {
+			t.Errorf("%s: expected %+v, got %+v", instrumentType, totals, got)
+		}
+	}
+
+	wantTotals := &FeeTotals{Commission: 0.5, ClearingFees: 0.18, RegulatoryFees: 0.07, ProprietaryIndexOptionFees: 0.65, Total: 1.4}
+	if !equal(&summary.Totals, wantTotals) {
+		t.Errorf("expected totals %+v, got %+v", wantTotals, summary.Totals)
+	}
+}
+
+func TestAccountsParseDates(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"items":[{"authority-level":"owner","account":{
+			"account-number":"5WT0001","opened-at":"2019-03-14T15:39:31.265+00:00","funding-date":"2019-03-18"}}]}}`)
+	})
+
+	accounts, err := session.Accounts()
+	if err != nil {
+		t.Fatalf("Accounts: %v", err)
+	}
+
+	if len(accounts) != 1 {
+		t.Fatalf("expected 1 account, got %d", len(accounts))
+	}
+
+	if want := time.Date(2019, 3, 18, 0, 0, 0, 0, time.UTC); !accounts[0].FundingDate.Equal(want) {
+		t.Errorf("expected funding date %s, got %s", want, accounts[0].FundingDate)
+	}
+
+	if want := time.Date(2019, 3, 14, 15, 39, 31, 265000000, time.UTC); !accounts[0].OpenedAt.Equal(want) {
+		t.Errorf("expected opened at %s, got %s", want, accounts[0].OpenedAt)
+	}
+}
+
+func TestPositionsByUnderlying(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"items":[
+			{"symbol":"AAPL","instrument-type":"Equity","underlying-symbol":"AAPL","quantity":100,"quantity-direction":"Long","multiplier":1},
+			{"symbol":"AAPL  240621C00200000","instrument-type":"Equity Option","underlying-symbol":"AAPL","quantity":1,"quantity-direction":"Short","multiplier":100},
+			{"symbol":"SPY","instrument-type":"Equity","underlying-symbol":"SPY","quantity":10,"quantity-direction":"Long","multiplier":1}
+		]}}`)
+	})
+
+	grouped, err := session.PositionsByUnderlying("5WT0001", PositionFilterOpts{})
+	if err != nil {
+		t.Fatalf("PositionsByUnderlying: %v", err)
+	}
+
+	if len(grouped) != 2 {
+		t.Fatalf("expected 2 underlyings, got %d", len(grouped))
+	}
+
+	if len(grouped["AAPL"]) != 2 {
+		t.Errorf("expected 2 AAPL positions, got %d", len(grouped["AAPL"]))
+	}
+
+	if len(grouped["SPY"]) != 1 || grouped["SPY"][0].Symbol != "SPY" {
+		t.Errorf("expected the SPY position under SPY, got %v", grouped["SPY"])
+	}
+}
+
+func TestTradingStatusCanSubmitOrders(t *testing.T) {
+	testCases := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"normal", `{"data":{"account-number":"5WT0001","is-frozen":false,"is-closed":false,"is-closing-only":false}}`, true},
+		{"frozen", `{"data":{"account-number":"5WT0001","is-frozen":true,"is-closed":false,"is-closing-only":false}}`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tc.body)
+			})
+
+			status, err := session.TradingStatus("5WT0001")
+			if err != nil {
+				t.Fatalf("TradingStatus: %v", err)
+			}
+
+			if got := status.CanSubmitOrders(); got != tc.want {
+				t.Errorf("expected CanSubmitOrders %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMarshalAfterNewSession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"session-token":"new-session-token","user":{"name":"Test Trader","username":"trader"}}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := apiBaseURL
+	apiBaseURL = server.URL
+	t.Cleanup(func() { apiBaseURL = previous })
+
+	session, err := NewSession("trader", "secret")
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+
+	data, err := session.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	restored, err := NewSessionFromBytes(data)
+	if err != nil {
+		t.Fatalf("NewSessionFromBytes: %v", err)
+	}
+
+	if got := restored.Token.Load(); got != "new-session-token" {
+		t.Errorf("expected session token to round-trip, got %v", got)
+	}
+
+	if got := restored.RememberToken.Load(); got != "" {
+		t.Errorf("expected an empty remember-me token, got %v", got)
+	}
+
+	if restored.Name != "Test Trader" {
+		t.Errorf("expected name to round-trip, got %q", restored.Name)
+	}
+}
+
+func TestCustomerAccounts(t *testing.T) {
+	var log requestLog
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		if r.URL.Path != "/customers/C0002/accounts" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":"not_permitted","message":"User not authorized"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"items":[{"authority-level":"full-trading","account":{"account-number":"5WT0002","nickname":"Client"}}]}}`)
+	})
+
+	accounts, err := session.CustomerAccounts("C0002")
+	if err != nil {
+		t.Fatalf("CustomerAccounts: %v", err)
+	}
+
+	if len(accounts) != 1 || accounts[0].AccountNumber != "5WT0002" {
+		t.Fatalf("expected account 5WT0002, got %+v", accounts)
+	}
+
+	if _, err := session.CustomerAccounts("C0003"); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("expected ErrNotAuthorized, got %v", err)
+	}
+
+	want := []string{"GET /customers/C0002/accounts", "GET /customers/C0003/accounts"}
+	if !reflect.DeepEqual(log.all(), want) {
+		t.Errorf("expected %v, got %v", want, log.all())
+	}
+}
+
+func TestGetOrderParsesOptionSpreadLegs(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"id":42,"account-number":"5WT0001","status":"Live","order-type":"Limit","time-in-force":"Day",
+			"price":"1.25","price-effect":"Credit","underlying-symbol":"SPY","legs":[
+			{"instrument-type":"Equity Option","symbol":"SPY   240621P00500000","quantity":"2","remaining-quantity":"2","quantity-direction":"Short","action":"Sell to Open","fills":[]},
+			{"instrument-type":"Equity Option","symbol":"SPY   240621P00495000","quantity":"2","remaining-quantity":"2","quantity-direction":"Long","action":"Buy to Open","fills":[]}
+		]}}`)
+	})
+
+	order, err := session.GetOrder("5WT0001", "42")
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+
+	if len(order.Legs) != 2 {
+		t.Fatalf("expected 2 legs, got %d", len(order.Legs))
+	}
+
+	wantDirections := []string{"Short", "Long"}
+	for idx, leg := range order.Legs {
+		if leg.QuantityDirection != wantDirections[idx] {
+			t.Errorf("leg %d: expected quantity direction %s, got %q", idx, wantDirections[idx], leg.QuantityDirection)
+		}
+
+		if leg.InstrumentType != EquityOption {
+			t.Errorf("leg %d: expected instrument type %s, got %s", idx, EquityOption, leg.InstrumentType)
+		}
+
+		if leg.Quantity != "2" {
+			t.Errorf("leg %d: expected quantity 2, got %q", idx, leg.Quantity)
+		}
+	}
+}
+
+func TestAuthorizationHeaderRefreshesExpiredSession(t *testing.T) {
+	var log requestLog
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		fmt.Fprint(w, `{"data":{"session-token":"refreshed-session-token","remember-token":"next-remember-token"}}`)
+	})
+	session.ExpiresOn = time.Now().Add(-time.Hour)
+	session.RememberToken.Store("remember-me")
+	session.RememberMeExpiresOn = time.Now().Add(time.Hour)
+
+	header, err := session.AuthorizationHeader()
+	if err != nil {
+		t.Fatalf("AuthorizationHeader: %v", err)
+	}
+
+	if header != "refreshed-session-token" {
+		t.Errorf("expected the refreshed session token, got %q", header)
+	}
+
+	if !session.ExpiresOn.After(time.Now()) {
+		t.Errorf("expected the session expiry to move forward, got %s", session.ExpiresOn)
+	}
+
+	want := []string{"POST /sessions"}
+	if !reflect.DeepEqual(log.all(), want) {
+		t.Errorf("expected %v, got %v", want, log.all())
+	}
+}
+
+func TestSubmitOrderWithWarningsReturnsOrderID(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"order":{"id":1234,"account-number":"5WT0001","status":"Received","order-type":"Limit","time-in-force":"Day","price":"180.0","price-effect":"Debit",
+			"legs":[{"instrument-type":"Equity","symbol":"AAPL","quantity":"10","remaining-quantity":"10","action":"Buy to Open","fills":[]}]},
+			"warnings":[{"code":"tif_next_valid_sesssion","message":"Your order will begin working during next valid session."}]}}`)
+	})
+
+	order := &Order{
+		TimeInForce: Day,
+		OrderType:   Limit,
+		Price:       180,
+		PriceEffect: Debit,
+		Legs:        []*Leg{{InstrumentType: Equity, Symbol: "AAPL", Quantity: 10, Action: BuyToOpen}},
+	}
+
+	resp, err := session.SubmitOrder("5WT0001", order)
+	if err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %d", len(resp.Warnings))
+	}
+
+	if id := resp.OrderID(); id != "1234" {
+		t.Errorf("expected order id 1234, got %q", id)
+	}
+
+	var missing *OrderResponse
+	if id := missing.OrderID(); id != "" {
+		t.Errorf("expected no order id for a nil response, got %q", id)
+	}
+}
+
+func TestGetOrderContingentPending(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"id":42,"account-number":"5WT0001","status":"Contingent","contingent-status":"Pending Condition",
+			"order-type":"Limit","time-in-force":"Day","price":"180.0","price-effect":"Debit",
+			"legs":[{"instrument-type":"Equity","symbol":"AAPL","quantity":"10","remaining-quantity":"10","action":"Buy to Open","fills":[]}],
+			"order-rule":{"conditions":[{"action":"route","symbol":"AAPL","instrument-type":"Equity","indicator":"last","comparator":"lte","threshold":"175.0","is-threshold-based-on-notional":false}]}}}`)
+	})
+
+	order, err := session.GetOrder("5WT0001", "42")
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+
+	if order.ContingentStatus != PendingCondition {
+		t.Errorf("expected contingent status %s, got %s", PendingCondition, order.ContingentStatus)
+	}
+
+	if !order.IsContingentPending() {
+		t.Error("expected an untriggered contingent order to be pending")
+	}
+
+	order.ContingentStatus = ConditionTriggered
+	if order.IsContingentPending() {
+		t.Error("expected a triggered contingent order not to be pending")
+	}
+}
+
+func TestOrderCapabilities(t *testing.T) {
+	var paths []string
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		fmt.Fprint(w, `{"data":{"active":true,"is-closing-only":false}}`)
+	})
+
+	equity, err := session.OrderCapabilities("AAPL", Equity)
+	if err != nil {
+		t.Fatalf("OrderCapabilities(AAPL): %v", err)
+	}
+
+	crypto, err := session.OrderCapabilities("BTC/USD", Cryptocurrency)
+	if err != nil {
+		t.Fatalf("OrderCapabilities(BTC/USD): %v", err)
+	}
+
+	if !equity.Supports(Stop, Day) {
+		t.Error("expected equities to allow stop day orders")
+	}
+
+	if crypto.Supports(Stop, GTC) {
+		t.Error("expected cryptocurrencies not to allow stop orders")
+	}
+
+	if !crypto.Supports(Limit, GTC) || crypto.Supports(Limit, Day) {
+		t.Errorf("expected cryptocurrencies to allow GTC but not day orders, got %v", crypto.TimeInForces)
+	}
+
+	if !equity.Active || !crypto.Active {
+		t.Error("expected both instruments to be active")
+	}
+
+	want := []string{"/instruments/equities/AAPL", "/instruments/cryptocurrencies/BTC%2FUSD"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected %v, got %v", want, paths)
+	}
+
+	if _, err := session.OrderCapabilities("AAPL", EquityOffering); !errors.Is(err, ErrUnsupportedInstrumentType) {
+		t.Errorf("expected ErrUnsupportedInstrumentType, got %v", err)
+	}
+}
+
+func TestSubmitAndWaitFillsAfterTwoPolls(t *testing.T) {
+	states := []string{"Live", "Filled"}
+
+	var log requestLog
+	var polls atomic.Int32
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		if r.Method == http.MethodPost {
+			fmt.Fprint(w, `{"data":{"order":{"id":"42","status":"Received"}}}`)
+			return
+		}
+
+		poll := int(polls.Add(1)) - 1
+		fmt.Fprintf(w, `{"data":{"id":"42","status":%q}}`, states[min(poll, len(states)-1)])
+	})
+
+	order := &Order{
+		TimeInForce: Day,
+		OrderType:   Market,
+		Legs:        []*Leg{{InstrumentType: Equity, Symbol: "AAPL", Quantity: 10, Action: BuyToOpen}},
+	}
+
+	status, err := session.SubmitAndWait(context.Background(), "5WT0001", order, time.Millisecond)
+	if err != nil {
+		t.Fatalf("SubmitAndWait: %v", err)
+	}
+
+	if status.Status != "Filled" {
+		t.Errorf("expected Filled, got %s", status.Status)
+	}
+
+	want := []string{"POST /accounts/5WT0001/orders", "GET /accounts/5WT0001/orders/42", "GET /accounts/5WT0001/orders/42"}
+	if !reflect.DeepEqual(log.all(), want) {
+		t.Errorf("expected %v, got %v", want, log.all())
+	}
+}
+
+func TestServerTimeSkew(t *testing.T) {
+	serverNow := time.Now().Add(90 * time.Second).UTC().Truncate(time.Second)
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", serverNow.Format(http.TimeFormat))
+		fmt.Fprint(w, `{"data":{"id":"me"}}`)
+	})
+
+	serverTime, err := session.ServerTime()
+	if err != nil {
+		t.Fatalf("ServerTime: %v", err)
+	}
+
+	if !serverTime.Equal(serverNow) {
+		t.Errorf("expected server time %s, got %s", serverNow, serverTime)
+	}
+
+	if skew := time.Until(serverTime); skew < 80*time.Second || skew > 90*time.Second {
+		t.Errorf("expected about 90s of clock skew, got %s", skew)
+	}
+}
+
+func TestSubmitOrderAcceptedThenRejected(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"order":{"id":1234,"account-number":"5WT0001","status":"Rejected","reject-reason":"Insufficient buying power",
+			"order-type":"Market","time-in-force":"Day",
+			"legs":[{"instrument-type":"Equity","symbol":"AAPL","quantity":"10","remaining-quantity":"10","action":"Buy to Open","fills":[]}]}}}`)
+	})
+
+	order := &Order{
+		TimeInForce: Day,
+		OrderType:   Market,
+		Legs:        []*Leg{{InstrumentType: Equity, Symbol: "AAPL", Quantity: 10, Action: BuyToOpen}},
+	}
+
+	resp, err := session.SubmitOrder("5WT0001", order)
+	if err != nil {
+		t.Fatalf("SubmitOrder: %v", err)
+	}
+
+	rejected, reason := resp.IsRejected()
+	if !rejected {
+		t.Fatal("expected the order to be rejected")
+	}
+
+	if reason != "Insufficient buying power" {
+		t.Errorf("expected reject reason, got %q", reason)
+	}
+
+	if id := resp.OrderID(); id != "1234" {
+		t.Errorf("expected order id 1234, got %q", id)
+	}
+}
+
+func TestAccountEndpointsReturnAccountNotFound(t *testing.T) {
+	session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":"record_not_found","message":"Account not found"}}`)
+	})
+
+	endpoints := map[string]func(accountNumber string) error{
+		"balances": func(accountNumber string) error {
+			_, err := session.Balance(accountNumber)
+			return err
+		},
+		"positions": func(accountNumber string) error {
+			_, err := session.Positions(accountNumber)
+			return err
+		},
+		"orders": func(accountNumber string) error {
+			_, _, err := session.Orders(accountNumber)
+			return err
+		},
+		"transactions": func(accountNumber string) error {
+			_, _, err := session.Transactions(accountNumber)
+			return err
+		},
+	}
+
+	for name, fn := range endpoints {
+		t.Run(name, func(t *testing.T) {
+			err := fn("5WTBOGUS")
+			if !errors.Is(err, ErrAccountNotFound) {
+				t.Errorf("expected ErrAccountNotFound, got %v", err)
+			}
+
+			if !errors.Is(err, ErrInvalidHTTPResponse) || !strings.Contains(err.Error(), "404") {
+				t.Errorf("expected the error to wrap the 404 status, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDefaultAccount(t *testing.T) {
+	testCases := []struct {
+		name    string
+		items   string
+		want    string
+		wantErr error
+	}{
+		{"single account", `{"authority-level":"read-only","account":{"account-number":"5WT0001"}}`, "5WT0001", nil},
+		{"one owned account", `{"authority-level":"full-trading","account":{"account-number":"5WT0001"}},
+			{"authority-level":"owner","account":{"account-number":"5WT0002"}}`, "5WT0002", nil},
+		{"several owned accounts", `{"authority-level":"owner","account":{"account-number":"5WT0001"}},
+			{"authority-level":"owner","account":{"account-number":"5WT0002"}}`, "", ErrNoDefaultAccount},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests atomic.Int32
+			session := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				fmt.Fprintf(w, `{"data":{"items":[%s]}}`, tc.items)
+			})
+
+			for idx := 0; idx < 2; idx++ {
+				account, err := session.DefaultAccount()
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+
+				if tc.wantErr == nil && account.AccountNumber != tc.want {
+					t.Errorf("expected account %s, got %s", tc.want, account.AccountNumber)
+				}
+			}
+
+			// a default account is cached; errors are not
+			wantRequests := int32(1)
+			if tc.wantErr != nil {
+				wantRequests = 2
+			}
+			if n := requests.Load(); n != wantRequests {
+				t.Errorf("expected %d requests, got %d", wantRequests, n)
+			}
+		})
+	}
+}
@@ -16,9 +16,16 @@
 package gotasty
 
 import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/goccy/go-json"
 )
 
 const UNK = "UNK"
@@ -46,9 +53,61 @@ type Session struct {
 	// remember token can be used exactly once and expire after 28 days
 	RememberToken *atomic.Value
 
+	// OAuth credentials - used instead of the remember token to refresh the
+	// session when it was created with NewOAuthSession
+	ClientSecret      string
+	OAuthRefreshToken string
+
 	Debug bool // print details of each response and request
 
+	Observer RequestObserver // notified of every request made with the session
+	Auditor  OrderAuditor    // notified when orders are submitted, replaced, or cancelled
+
+	MaxResponseBytes int64 // maximum size of a response body; 0 means unlimited
+
 	RefreshLocker *sync.Mutex
+
+	headers map[string]string // additional headers sent with each request
+	owner   *Session          // session that refreshes the tokens of a WithHeader copy
+
+	defaultAccount *atomic.Pointer[Account] // cached result of DefaultAccount
+}
+
+// SessionStatus is a point-in-time summary of a session's tokens
+type SessionStatus struct {
+	TokenExpiresOn         time.Time
+	RememberTokenExpiresOn time.Time
+
+	TokenExpiresIn         time.Duration // negative if the token has expired
+	RememberTokenExpiresIn time.Duration // negative if the remember token has expired
+
+	TokenValid bool // session token has not expired
+
+	// session token can be refreshed with a remember-me token or OAuth
+	// refresh token once it expires
+	CanRefresh bool
+}
+
+// Status reports when the session's tokens expire and whether the session can
+// be refreshed. It does not make any network calls. For a copy made by
+// WithHeader the status of the original session is reported.
+func (session *Session) Status() SessionStatus {
+	now := time.Now()
+	owner := session.tokenOwner()
+
+	status := SessionStatus{
+		TokenExpiresOn:         owner.ExpiresOn,
+		RememberTokenExpiresOn: owner.RememberMeExpiresOn,
+		TokenExpiresIn:         owner.ExpiresOn.Sub(now),
+		RememberTokenExpiresIn: owner.RememberMeExpiresOn.Sub(now),
+		TokenValid:             owner.ExpiresOn.After(now),
+	}
+
+	rememberToken := loadToken(owner.RememberToken)
+	status.CanRefresh = owner.OAuthRefreshToken != "" ||
+		(rememberToken != "" && owner.RememberMeExpiresOn.After(now))
+
+	return status
 }
 
 // SessionOpts provide additional settings when creating a new tastytrade Open API session
@@ -62,6 +121,74 @@ type SessionOpts struct {
 
 	// enable debug mode which prints the status of each request
 	Debug bool
+
+	// receive a callback at the start and end of every request, e.g. to
+	// collect metrics
+	Observer RequestObserver
+
+	// receive an audit record each time an order is submitted, replaced,
+	// or cancelled
+	Auditor OrderAuditor
+
+	// fail requests whose response body is larger than this many bytes;
+	// 0 means unlimited
+	MaxResponseBytes int64
+}
+
+// RequestObserver is notified of every request made to the tastytrade Open
+// API. It can be used to collect request counts, latencies, and error rates
+// without the library depending on a specific metrics package. endpoint is
+// the route template of the request, e.g. /accounts/{account}/orders/{id},
+// rather than its path so that it can be used as a metric label.
+type RequestObserver interface {
+	// OnRequestStart is called before a request is sent to endpoint
+	OnRequestStart(endpoint string)
+
+	// OnRequestEnd is called after a request completes. statusCode is 0 if
+	// no response was received.
+	OnRequestEnd(endpoint string, dur time.Duration, statusCode int, err error)
+}
+
+// OrderAuditor is notified of order lifecycle events, e.g. to keep an audit
+// trail of every order submitted or cancelled by the application.
+type OrderAuditor interface {
+	OnOrderEvent(event *OrderEvent)
+}
+
+type OrderEventAction int
+
+const (
+	UndefinedOrderEventAction OrderEventAction = iota
+	OrderSubmitted
+	OrderReplaced
+	OrderCancelled
+)
+
+func (orderEventAction OrderEventAction) String() string {
+	switch orderEventAction {
+	case OrderSubmitted:
+		return "submit"
+	case OrderReplaced:
+		return "replace"
+	case OrderCancelled:
+		return "cancel"
+	default:
+		return UNK
+	}
+}
+
+// OrderEvent records the outcome of submitting, replacing, or cancelling an order
+type OrderEvent struct {
+	Action        OrderEventAction
+	Time          time.Time
+	AccountNumber string
+	OrderID       string
+
+	Order    *Order       // order that was sent; nil when cancelling
+	Status   *OrderStatus // status returned by tastytrade, if any
+	Errors   []*ErrorMsg
+	Warnings []*ErrorMsg
+	Err      error // error returned to the caller, if any
 }
 
 // User is used to authenticate a user session
@@ -119,6 +246,21 @@ type PositionFilterOpts struct {
 	NetPositions           bool
 	IncludeClosedPositions bool
 	IncludeMarks           bool
+
+	// Include positions that were closed within the given window. Ignored
+	// if IncludeClosedPositions is set. Closed positions are purged
+	// overnight so this is mostly useful intraday.
+	ClosedWithin time.Duration
+}
+
+// Pagination describes the page of results returned by a list endpoint
+type Pagination struct {
+	PerPage          int `json:"per-page"`
+	PageOffset       int `json:"page-offset"`
+	ItemOffset       int `json:"item-offset"`
+	TotalItems       int `json:"total-items"`
+	TotalPages       int `json:"total-pages"`
+	CurrentItemCount int `json:"current-item-count"`
 }
 
 type TransactionFilterOpts struct {
@@ -144,6 +286,71 @@ type TransactionFilterOpts struct {
 	PageOffset int
 }
 
+// Validate checks that the transaction types and sub-types are known values
+func (filter TransactionFilterOpts) Validate() error {
+	for _, transactionType := range filter.TransactionTypes {
+		if !slices.Contains(TransactionTypes, transactionType) {
+			return fmt.Errorf("%w: %q", ErrUnknownTransactionType, transactionType)
+		}
+	}
+
+	for _, subType := range filter.TransactionSubTypes {
+		if !slices.Contains(TransactionSubTypes, subType) {
+			return fmt.Errorf("%w: %q", ErrUnknownTransactionSubType, subType)
+		}
+	}
+
+	return nil
+}
+
+// Known transaction types
+const (
+	TransactionTypeACAT           = "ACAT"
+	TransactionTypeMoneyMovement  = "Money Movement"
+	TransactionTypeReceiveDeliver = "Receive Deliver"
+	TransactionTypeTrade          = "Trade"
+)
+
+// TransactionTypes lists the transaction types accepted by TransactionFilterOpts
+var TransactionTypes = []string{
+	TransactionTypeACAT,
+	TransactionTypeMoneyMovement,
+	TransactionTypeReceiveDeliver,
+	TransactionTypeTrade,
+}
+
+// TransactionSubTypes lists the transaction sub-types accepted by TransactionFilterOpts
+var TransactionSubTypes = []string{
+	"ACAT",
+	"Assignment",
+	"Balance Adjustment",
+	"Buy",
+	"Buy to Close",
+	"Buy to Open",
+	"Cash Merger",
+	"Cash Settled Assignment",
+	"Cash Settled Exercise",
+	"Credit Interest",
+	"Debit Interest",
+	"Deposit",
+	"Dividend",
+	"Exercise",
+	"Expiration",
+	"Fee",
+	"Forward Split",
+	"Futures Settlement",
+	"Mark to Market",
+	"Reverse Split",
+	"Sell",
+	"Sell to Close",
+	"Sell to Open",
+	"Special Dividend",
+	"Stock Merger",
+	"Symbol Change",
+	"Transfer",
+	"Withdrawal",
+}
+
 type OrdersFilterOpts struct {
 	StartDate time.Time
 	EndDate   time.Time
@@ -164,21 +371,99 @@ type OrdersFilterOpts struct {
 	PageOffset int
 }
 
+// DeleteOrderOpts provide additional settings when deleting an order
+type DeleteOrderOpts struct {
+	// treat an order that is already filled, cancelled, or otherwise terminal
+	// as successfully deleted and return its current status
+	IgnoreTerminal bool
+}
+
+// WaitForOrderOpts provide additional settings when waiting for an order
+type WaitForOrderOpts struct {
+	// number of consecutive failed polls to tolerate before giving up
+	MaxConsecutiveFailures int
+
+	// longest wait between polls after consecutive failures. Defaults to
+	// 1 minute or the poll interval if it is longer.
+	MaxBackoff time.Duration
+}
+
+// FlattenAccountOpts provide additional settings when flattening an account
+type FlattenAccountOpts struct {
+	// must be set to confirm that every live order should be cancelled and
+	// every open position closed
+	Confirm bool
+
+	// limit price of the closing order for each position, keyed by symbol.
+	// Required for Limit and MarketableLimit orders.
+	Prices map[string]float64
+}
+
 // Account stores information about the accounts available to the current customer
 type Account struct {
-	AccountNumber     string    `json:"account-number"`    // account number, e.g. 5WT0001
-	ExternalID        string    `json:"external-id"`       // external identifier, e.g. A0000196557
-	OpenedAt          time.Time `json:"opened-at"`         // time the account was opened
-	Nickname          string    `json:"nickname"`          // customer assigned nickname for account
-	AccountType       string    `json:"account-type-name"` // type of account
-	DayTraderStatus   bool      `json:"day-trader-status"` // if account is flagged as a pattern day trader
-	IsFirmError       bool      `json:"is-firm-error"`
-	IsFirmProprietary bool      `json:"is-firm-proprietary"`
-	IsTestDrive       bool      `json:"is-test-drive"`
-	MarginOrCash      string    `json:"margin-or-cash"`
-	IsForeign         bool      `json:"is-foreign"`
-	FundingDate       time.Time `json:"funding-date"`
-	AuthorityLevel    string    `json:"authority-level"`
+	AccountNumber     string         `json:"account-number"`    // account number, e.g. 5WT0001
+	ExternalID        string         `json:"external-id"`       // external identifier, e.g. A0000196557
+	OpenedAt          time.Time      `json:"opened-at"`         // time the account was opened
+	Nickname          string         `json:"nickname"`          // customer assigned nickname for account
+	AccountType       string         `json:"account-type-name"` // type of account
+	DayTraderStatus   bool           `json:"day-trader-status"` // if account is flagged as a pattern day trader
+	IsFirmError       bool           `json:"is-firm-error"`
+	IsFirmProprietary bool           `json:"is-firm-proprietary"`
+	IsTestDrive       bool           `json:"is-test-drive"`
+	MarginOrCash      string         `json:"margin-or-cash"`
+	IsForeign         bool           `json:"is-foreign"`
+	FundingDate       time.Time      `json:"funding-date"`
+	AuthorityLevel    AuthorityLevel `json:"authority-level"`
+}
+
+// CanTrade returns true if the customer's authority level on the account
+// permits placing orders
+func (account *Account) CanTrade() bool {
+	switch account.AuthorityLevel {
+	case Owner, FullTrading:
+		return true
+	default:
+		return false
+	}
+}
+
+type AuthorityLevel int
+
+const (
+	UndefinedAuthorityLevel AuthorityLevel = iota
+	Owner
+	FullTrading
+	ReadOnly
+)
+
+func AuthorityLevelFromString(input string) AuthorityLevel {
+	switch input {
+	case "owner":
+		return Owner
+	case "full-trading":
+		return FullTrading
+	case "read-only":
+		return ReadOnly
+	}
+
+	return UndefinedAuthorityLevel
+}
+
+func (authorityLevel AuthorityLevel) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + authorityLevel.String() + "\""), nil
+}
+
+func (authorityLevel AuthorityLevel) String() string {
+	switch authorityLevel {
+	case Owner:
+		return "owner"
+	case FullTrading:
+		return "full-trading"
+	case ReadOnly:
+		return "read-only"
+	default:
+		return UNK
+	}
 }
 
 // Balance details for a specific account
@@ -231,6 +516,103 @@ type Balance struct {
 	UpdatedAt                          time.Time `json:"updated-at"`
 }
 
+// BuyingPowerPercentUsed returns the percentage (0-100) of the account's
+// net liquidating value that is not available as derivative buying power:
+//
+//	(NetLiquidatingValue - DerivativeBuyingPower) / NetLiquidatingValue * 100
+//
+// Only DerivativeBuyingPower is used; EquityBuyingPower is not considered, so
+// the result does not reflect margin available for stock purchases. The
+// result is clamped to [0, 100] and is 0 when net-liq is not positive.
+func (balance *Balance) BuyingPowerPercentUsed() float64 {
+	if balance.NetLiquidatingValue <= 0 {
+		return 0
+	}
+
+	used := (balance.NetLiquidatingValue - balance.DerivativeBuyingPower) / balance.NetLiquidatingValue * 100
+	return math.Min(math.Max(used, 0), 100)
+}
+
+// CanDayTrade returns true if an order costing orderCost can be day traded
+// without exceeding the account's day-trading buying power, i.e. without
+// risking a day-trade call
+func (balance *Balance) CanDayTrade(orderCost float64) bool {
+	return math.Abs(orderCost) <= balance.DayTradingBuyingPower
+}
+
+// DayTradeCheck is the result of comparing an order's buying power effect with
+// the account's day-trading buying power
+type DayTradeCheck struct {
+	OrderCost             float64        // buying power the order would use according to a dry-run
+	DayTradingBuyingPower float64        // account's current day-trading buying power
+	CanDayTrade           bool           // false if day trading the order risks a day-trade call
+	DryRun                *OrderResponse // dry-run response used to calculate OrderCost
+}
+
+// BalanceDelta is the change in an account's key monetary balances between
+// two balance snapshots
+type BalanceDelta struct {
+	AccountNumber           string  `json:"account-number"`
+	NetLiquidatingValue     float64 `json:"net-liquidating-value"`
+	CashBalance             float64 `json:"cash-balance"`
+	EquityBuyingPower       float64 `json:"equity-buying-power"`
+	DerivativeBuyingPower   float64 `json:"derivative-buying-power"`
+	DayTradingBuyingPower   float64 `json:"day-trading-buying-power"`
+	CashAvailableToWithdraw float64 `json:"cash-available-to-withdraw"`
+	MaintenanceRequirement  float64 `json:"maintenance-requirement"`
+}
+
+// BalanceDiff returns the change from prev to cur (cur - prev) for the key
+// monetary fields of the balance
+func BalanceDiff(prev, cur *Balance) *BalanceDelta {
+	return &BalanceDelta{
+		AccountNumber:           cur.AccountNumber,
+		NetLiquidatingValue:     cur.NetLiquidatingValue - prev.NetLiquidatingValue,
+		CashBalance:             cur.CashBalance - prev.CashBalance,
+		EquityBuyingPower:       cur.EquityBuyingPower - prev.EquityBuyingPower,
+		DerivativeBuyingPower:   cur.DerivativeBuyingPower - prev.DerivativeBuyingPower,
+		DayTradingBuyingPower:   cur.DayTradingBuyingPower - prev.DayTradingBuyingPower,
+		CashAvailableToWithdraw: cur.CashAvailableToWithdraw - prev.CashAvailableToWithdraw,
+		MaintenanceRequirement:  cur.MaintenanceRequirement - prev.MaintenanceRequirement,
+	}
+}
+
+// TradingStatus describes the account's trading restrictions.
+// IsPortfolioMarginEligibleRaw is the eligibility flag as reported by the
+// API; prefer IsPortfolioMarginEligible, which also counts enabled accounts.
+type TradingStatus struct {
+	AccountNumber                string    `json:"account-number"`
+	DayTradeCount                int       `json:"day-trade-count"`
+	IsPatternDayTrader           bool      `json:"is-pattern-day-trader"`
+	IsFrozen                     bool      `json:"is-frozen"`
+	IsClosed                     bool      `json:"is-closed"`
+	IsClosingOnly                bool      `json:"is-closing-only"`
+	IsPortfolioMarginEligibleRaw bool      `json:"is-portfolio-margin-eligible"`
+	IsPortfolioMarginEnabled     bool      `json:"is-portfolio-margin-enabled"`
+	PDTResetOn                   time.Time `json:"pdt-reset-on"`
+	UpdatedAt                    time.Time `json:"updated-at"`
+}
+
+// CanSubmitOrders returns false if the account is frozen or closed. Accounts
+// restricted to closing only can still submit orders that reduce positions.
+func (tradingStatus *TradingStatus) CanSubmitOrders() bool {
+	return !tradingStatus.IsFrozen && !tradingStatus.IsClosed
+}
+
+// IsPortfolioMarginEligible returns true if the account qualifies for
+// portfolio margin. Accounts that already use portfolio margin are eligible.
+func (tradingStatus *TradingStatus) IsPortfolioMarginEligible() bool {
+	return tradingStatus.IsPortfolioMarginEligibleRaw || tradingStatus.IsPortfolioMarginEnabled
+}
+
+// PortfolioMarginStatus reports whether an account qualifies for and is
+// currently using portfolio margin
+type PortfolioMarginStatus struct {
+	AccountNumber string `json:"account-number"`
+	Eligible      bool   `json:"eligible"`
+	Enabled       bool   `json:"enabled"`
+}
+
 // Position stores details about the positions held in an account
 //
 // A position with a quantity of 0 is considered closed. These are purged
@@ -247,6 +629,7 @@ type Position struct {
 	Symbol                        string    `json:"symbol"`
 	InstrumentType                string    `json:"instrument-type"`
 	UnderlyingSymbol              string    `json:"underlying-symbol"`
+	UnderlyingProductCode         string    `json:"underlying-product-code"`
 	Quantity                      float64   `json:"quantity"`
 	QuantityDirection             string    `json:"quantity-direction"`
 	ClosePrice                    float64   `json:"close-price"`
@@ -269,6 +652,213 @@ type Position struct {
 	UpdatedAt                     time.Time `json:"updated-at"`
 }
 
+// FilterOpenOnly returns the positions that have a non-zero quantity. Closed
+// positions (quantity of 0) are returned by the API when
+// `PositionFilterOpts.IncludeClosedPositions` is set until they are purged
+// overnight.
+func FilterOpenOnly(positions []*Position) []*Position {
+	open := make([]*Position, 0, len(positions))
+	for _, position := range positions {
+		if position.Quantity != 0 {
+			open = append(open, position)
+		}
+	}
+	return open
+}
+
+// PortfolioSnapshot is the aggregate state of a list of positions keyed by
+// underlying symbol. Snapshots taken at different times can be compared with
+// reflect.DeepEqual to detect changes.
+type PortfolioSnapshot struct {
+	Underlyings map[string]*UnderlyingSnapshot `json:"underlyings"`
+}
+
+// UnderlyingSnapshot is the aggregate of all positions in a single underlying.
+// Quantity is signed (short positions are negative) while AveragePrice and
+// Mark are weighted by the absolute quantity of each position.
+type UnderlyingSnapshot struct {
+	UnderlyingSymbol string  `json:"underlying-symbol"`
+	Quantity         float64 `json:"quantity"`
+	AveragePrice     float64 `json:"average-price"`
+	Mark             float64 `json:"mark"`
+}
+
+// PositionsSnapshot aggregates positions by underlying symbol. The position's
+// close price is used as its mark.
+func PositionsSnapshot(positions []*Position) *PortfolioSnapshot {
+	snapshot := &PortfolioSnapshot{
+		Underlyings: make(map[string]*UnderlyingSnapshot),
+	}
+
+	weights := make(map[string]float64)
+	for _, position := range positions {
+		underlying, ok := snapshot.Underlyings[position.UnderlyingSymbol]
+		if !ok {
+			underlying = &UnderlyingSnapshot{UnderlyingSymbol: position.UnderlyingSymbol}
+			snapshot.Underlyings[position.UnderlyingSymbol] = underlying
+		}
+
+		quantity := position.Quantity
+		if position.QuantityDirection == "Short" {
+			quantity = -quantity
+		}
+		underlying.Quantity += quantity
+
+		weight := math.Abs(position.Quantity)
+		underlying.AveragePrice += position.AverageOpenPrice * weight
+		underlying.Mark += position.ClosePrice * weight
+		weights[position.UnderlyingSymbol] += weight
+	}
+
+	for symbol, underlying := range snapshot.Underlyings {
+		if weight := weights[symbol]; weight > 0 {
+			underlying.AveragePrice /= weight
+			underlying.Mark /= weight
+		}
+	}
+
+	return snapshot
+}
+
+// MarketDataSnapshot is a point-in-time quote for a symbol
+type MarketDataSnapshot struct {
+	Symbol         string               `json:"symbol"`
+	InstrumentType InstrumentTypeChoice `json:"instrument-type"`
+	UpdatedAt      time.Time            `json:"updated-at"`
+	Bid            float64              `json:"bid"`
+	BidSize        float64              `json:"bid-size"`
+	Ask            float64              `json:"ask"`
+	AskSize        float64              `json:"ask-size"`
+	Mid            float64              `json:"mid"`
+	Mark           float64              `json:"mark"`
+	Last           float64              `json:"last"`
+	Open           float64              `json:"open"`
+	DayHighPrice   float64              `json:"day-high-price"`
+	DayLowPrice    float64              `json:"day-low-price"`
+	Close          float64              `json:"close"`
+	PrevClose      float64              `json:"prev-close"`
+	Volume         float64              `json:"volume"`
+}
+
+// OptionInstrument describes an equity option contract from the instruments API
+type OptionInstrument struct {
+	Symbol            string    `json:"symbol"`
+	StreamerSymbol    string    `json:"streamer-symbol"`
+	UnderlyingSymbol  string    `json:"underlying-symbol"`
+	RootSymbol        string    `json:"root-symbol"`
+	OptionType        string    `json:"option-type"` // C for calls and P for puts
+	StrikePrice       float64   `json:"strike-price"`
+	ExpirationDate    time.Time `json:"expiration-date"`
+	ExpiresAt         time.Time `json:"expires-at"`
+	DaysToExpiration  int64     `json:"days-to-expiration"`
+	ExerciseStyle     string    `json:"exercise-style"`
+	ExpirationType    string    `json:"expiration-type"`
+	SettlementType    string    `json:"settlement-type"`
+	SharesPerContract float64   `json:"shares-per-contract"`
+	Active            bool      `json:"active"`
+	IsClosingOnly     bool      `json:"is-closing-only"`
+}
+
+// RollCandidate finds the contract in chain that a position in symbol would
+// be rolled to: the same option type in the next expiration with the strike
+// closest to the current strike.
+func RollCandidate(chain []*OptionInstrument, symbol string) (*OptionInstrument, error) {
+	var current *OptionInstrument
+	for _, option := range chain {
+		if option.Symbol == symbol {
+			current = option
+			break
+		}
+	}
+
+	if current == nil {
+		return nil, fmt.Errorf("%w: %s not found in option chain", ErrNoRollCandidate, symbol)
+	}
+
+	var nextExpiration time.Time
+	for _, option := range chain {
+		if option.OptionType != current.OptionType || !option.Active || option.IsClosingOnly ||
+			!option.ExpirationDate.After(current.ExpirationDate) {
+			continue
+		}
+
+		if nextExpiration.IsZero() || option.ExpirationDate.Before(nextExpiration) {
+			nextExpiration = option.ExpirationDate
+		}
+	}
+
+	var candidate *OptionInstrument
+	for _, option := range chain {
+		if option.OptionType != current.OptionType || !option.Active || option.IsClosingOnly ||
+			!option.ExpirationDate.Equal(nextExpiration) {
+			continue
+		}
+
+		if candidate == nil || math.Abs(option.StrikePrice-current.StrikePrice) < math.Abs(candidate.StrikePrice-current.StrikePrice) {
+			candidate = option
+		}
+	}
+
+	if candidate == nil {
+		return nil, fmt.Errorf("%w: no later expiration for %s", ErrNoRollCandidate, symbol)
+	}
+
+	return candidate, nil
+}
+
+// OptionSymbol is the decoded form of an OCC option symbol
+type OptionSymbol struct {
+	RootSymbol     string    `json:"root-symbol"`
+	ExpirationDate time.Time `json:"expiration-date"`
+	OptionType     string    `json:"option-type"` // C for calls and P for puts
+	StrikePrice    float64   `json:"strike-price"`
+}
+
+// ParseOptionSymbol decodes an OCC option symbol such as
+// `AAPL  191004P00275000` into its root, expiration, type, and strike
+func ParseOptionSymbol(symbol string) (*OptionSymbol, error) {
+	match := occSymbolRegex.FindStringSubmatch(symbol)
+	if match == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidOptionSymbol, symbol)
+	}
+
+	expiration, err := time.Parse("060102", match[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrInvalidOptionSymbol, symbol, err)
+	}
+
+	strike, err := strconv.ParseInt(match[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrInvalidOptionSymbol, symbol, err)
+	}
+
+	return &OptionSymbol{
+		RootSymbol:     match[1],
+		ExpirationDate: expiration,
+		OptionType:     match[3],
+		StrikePrice:    float64(strike) / 1000,
+	}, nil
+}
+
+// OrderCapabilities lists the order types and time-in-force values that can
+// be used when trading an instrument
+type OrderCapabilities struct {
+	Symbol         string               `json:"symbol"`
+	InstrumentType InstrumentTypeChoice `json:"instrument-type"`
+	Active         bool                 `json:"active"`
+	IsClosingOnly  bool                 `json:"is-closing-only"`
+	OrderTypes     []OrderTypeChoice    `json:"order-types"`
+	TimeInForces   []TimeInForceChoice  `json:"time-in-forces"`
+}
+
+// Supports returns true if an order with orderType and timeInForce can be
+// placed for the instrument
+func (orderCapabilities *OrderCapabilities) Supports(orderType OrderTypeChoice, timeInForce TimeInForceChoice) bool {
+	return orderCapabilities.Active &&
+		slices.Contains(orderCapabilities.OrderTypes, orderType) &&
+		slices.Contains(orderCapabilities.TimeInForces, timeInForce)
+}
+
 type TimeInForceChoice int
 
 const (
@@ -357,7 +947,7 @@ func OrderTypeFromString(input string) OrderTypeChoice {
 		return MarketableLimit
 	case "Stop":
 		return Stop
-	case "StopLimit":
+	case "Stop Limit", "StopLimit":
 		return StopLimit
 	case "Notional Market":
 		return NotionalMarket
@@ -381,7 +971,7 @@ func (orderType OrderTypeChoice) String() string {
 	case Stop:
 		return "Stop"
 	case StopLimit:
-		return "StopLimit"
+		return "Stop Limit"
 	case NotionalMarket:
 		return "Notional Market"
 	default:
@@ -408,6 +998,24 @@ func EffectFromString(input string) Effect {
 	return UndefinedEffect
 }
 
+// EffectFromStringStrict parses input like EffectFromString but returns
+// ErrUnknownEffect for values it does not recognize. Empty and "None"
+// values are treated as an absent effect and return UndefinedEffect without
+// an error.
+func EffectFromStringStrict(input string) (Effect, error) {
+	switch input {
+	case "", "None":
+		return UndefinedEffect, nil
+	}
+
+	effect := EffectFromString(input)
+	if effect == UndefinedEffect {
+		return UndefinedEffect, fmt.Errorf("%w: %q", ErrUnknownEffect, input)
+	}
+
+	return effect, nil
+}
+
 func (effect Effect) MarshalJSON() ([]byte, error) {
 	return []byte("\"" + effect.String() + "\""), nil
 }
@@ -477,6 +1085,28 @@ func (instrumentType InstrumentTypeChoice) String() string {
 	}
 }
 
+// MarketDataParamKey returns the query parameter key used by the market-data
+// endpoints for the instrument type, e.g. `equity-option[]`. Like other array
+// parameters in the API the key has a `[]` suffix so that it can be repeated
+// for each symbol. An empty string is returned for instrument types that
+// market-data does not support.
+func (instrumentType InstrumentTypeChoice) MarketDataParamKey() string {
+	switch instrumentType {
+	case Cryptocurrency:
+		return "cryptocurrency[]"
+	case Equity:
+		return "equity[]"
+	case EquityOption:
+		return "equity-option[]"
+	case Future:
+		return "future[]"
+	case FutureOption:
+		return "future-option[]"
+	default:
+		return ""
+	}
+}
+
 type ActionType int
 
 const (
@@ -550,6 +1180,10 @@ func ActionConditionFromString(input string) ActionCondition {
 	return UndefinedActionCondition
 }
 
+func (actionCondition ActionCondition) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + actionCondition.String() + "\""), nil
+}
+
 func (actionCondition ActionCondition) String() string {
 	switch actionCondition {
 	case Route:
@@ -580,6 +1214,10 @@ func IndicatorFromString(input string) IndicatorType {
 	return UndefinedIndicatorType
 }
 
+func (indicatorType IndicatorType) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + indicatorType.String() + "\""), nil
+}
+
 func (indicatorType IndicatorType) String() string {
 	switch indicatorType {
 	case Last:
@@ -610,6 +1248,10 @@ func ComparatorFromString(input string) ComparatorType {
 	return UndefinedComparator
 }
 
+func (comparatorType ComparatorType) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + comparatorType.String() + "\""), nil
+}
+
 func (comparatorType ComparatorType) String() string {
 	switch comparatorType {
 	case GTE:
@@ -621,6 +1263,139 @@ func (comparatorType ComparatorType) String() string {
 	}
 }
 
+type ConfirmationStatus int
+
+const (
+	UndefinedConfirmationStatus ConfirmationStatus = iota
+	Confirmed
+	PendingConfirmation
+)
+
+func ConfirmationStatusFromString(input string) ConfirmationStatus {
+	switch strings.ToLower(input) {
+	case "confirmed":
+		return Confirmed
+	case "pending", "pending-confirmation", "pending confirmation":
+		return PendingConfirmation
+	}
+
+	return UndefinedConfirmationStatus
+}
+
+func (confirmationStatus ConfirmationStatus) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + confirmationStatus.String() + "\""), nil
+}
+
+func (confirmationStatus ConfirmationStatus) String() string {
+	switch confirmationStatus {
+	case Confirmed:
+		return "Confirmed"
+	case PendingConfirmation:
+		return "Pending"
+	default:
+		return UNK
+	}
+}
+
+type ContingentStatus int
+
+const (
+	UndefinedContingentStatus ContingentStatus = iota
+	PendingCondition
+	ConditionTriggered
+)
+
+func ContingentStatusFromString(input string) ContingentStatus {
+	switch strings.ToLower(input) {
+	case "pending condition", "pending":
+		return PendingCondition
+	case "triggered":
+		return ConditionTriggered
+	}
+
+	return UndefinedContingentStatus
+}
+
+func (contingentStatus ContingentStatus) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + contingentStatus.String() + "\""), nil
+}
+
+func (contingentStatus ContingentStatus) String() string {
+	switch contingentStatus {
+	case PendingCondition:
+		return "Pending Condition"
+	case ConditionTriggered:
+		return "Triggered"
+	default:
+		return UNK
+	}
+}
+
+type RejectCode int
+
+const (
+	UndefinedRejectCode RejectCode = iota
+	InsufficientBuyingPower
+	InsufficientPosition
+	MarketClosed
+	InvalidSymbol
+	InvalidPrice
+	DuplicateOrder
+	OtherReject
+)
+
+// RejectCodeFromString maps a reject reason onto a RejectCode by looking for
+// well-known phrases. Empty input returns UndefinedRejectCode and
+// unrecognized input returns OtherReject.
+func RejectCodeFromString(input string) RejectCode {
+	if input == "" {
+		return UndefinedRejectCode
+	}
+
+	reason := strings.ToLower(input)
+	switch {
+	case strings.Contains(reason, "buying power"):
+		return InsufficientBuyingPower
+	case strings.Contains(reason, "insufficient shares"), strings.Contains(reason, "insufficient position"),
+		strings.Contains(reason, "not enough shares"):
+		return InsufficientPosition
+	case strings.Contains(reason, "market is closed"), strings.Contains(reason, "market closed"),
+		strings.Contains(reason, "outside of trading hours"):
+		return MarketClosed
+	case strings.Contains(reason, "invalid symbol"), strings.Contains(reason, "unknown symbol"),
+		strings.Contains(reason, "symbol not found"):
+		return InvalidSymbol
+	case strings.Contains(reason, "invalid price"), strings.Contains(reason, "price increment"),
+		strings.Contains(reason, "tick size"):
+		return InvalidPrice
+	case strings.Contains(reason, "duplicate"):
+		return DuplicateOrder
+	default:
+		return OtherReject
+	}
+}
+
+func (rejectCode RejectCode) String() string {
+	switch rejectCode {
+	case InsufficientBuyingPower:
+		return "insufficient buying power"
+	case InsufficientPosition:
+		return "insufficient position"
+	case MarketClosed:
+		return "market closed"
+	case InvalidSymbol:
+		return "invalid symbol"
+	case InvalidPrice:
+		return "invalid price"
+	case DuplicateOrder:
+		return "duplicate order"
+	case OtherReject:
+		return "other"
+	default:
+		return UNK
+	}
+}
+
 type Transaction struct {
 	ID                               int64                `json:"id"`
 	AccountNumber                    string               `json:"account-number"`
@@ -669,6 +1444,123 @@ type Transaction struct {
 	CostBasisReconciliationDate      time.Time            `json:"cost-basis-reconciliation-date"`
 }
 
+// TotalLotQuantity returns the sum of the quantity of each lot in the transaction
+func (transaction *Transaction) TotalLotQuantity() float64 {
+	var total float64
+	for _, lot := range transaction.Lots {
+		total += lot.Quantity
+	}
+	return total
+}
+
+// ReconcileLots checks that the lot quantities add up to the transaction
+// quantity. Transactions without lots are considered reconciled.
+func (transaction *Transaction) ReconcileLots() error {
+	if len(transaction.Lots) == 0 {
+		return nil
+	}
+
+	total := transaction.TotalLotQuantity()
+	if math.Abs(total-transaction.Quantity) > 1e-9 {
+		return fmt.Errorf("%w: transaction %d has quantity %g but lots total %g", ErrLotQuantityMismatch, transaction.ID, transaction.Quantity, total)
+	}
+
+	return nil
+}
+
+// Transfer is a funding event such as an ACH deposit or wire withdrawal
+type Transfer struct {
+	ID              int64     `json:"id"`
+	AccountNumber   string    `json:"account-number"`
+	TransferType    string    `json:"transfer-type"` // transaction sub-type, e.g. Deposit or Withdrawal
+	Description     string    `json:"description"`
+	Amount          float64   `json:"amount"`
+	AmountEffect    Effect    `json:"amount-effect"`
+	ExecutedAt      time.Time `json:"executed-at"`
+	TransactionDate time.Time `json:"transaction-date"`
+}
+
+// FeeTotals sums the commissions and fees paid on a set of transactions.
+// Amounts are positive when paid and negative when rebated.
+type FeeTotals struct {
+	Commission                 float64 `json:"commission"`
+	ClearingFees               float64 `json:"clearing-fees"`
+	RegulatoryFees             float64 `json:"regulatory-fees"`
+	ProprietaryIndexOptionFees float64 `json:"proprietary-index-option-fees"`
+	Total                      float64 `json:"total"`
+}
+
+// CommissionSummary breaks down the commissions and fees an account paid
+// over a period of time by instrument type
+type CommissionSummary struct {
+	AccountNumber    string                              `json:"account-number"`
+	Start            time.Time                           `json:"start"`
+	End              time.Time                           `json:"end"`
+	Totals           FeeTotals                           `json:"totals"`
+	ByInstrumentType map[InstrumentTypeChoice]*FeeTotals `json:"by-instrument-type"`
+}
+
+// add accumulates the commission and fees of trx
+func (feeTotals *FeeTotals) add(trx *Transaction) {
+	paid := func(amount float64, effect Effect) float64 {
+		if effect == Credit {
+			return -amount
+		}
+		return amount
+	}
+
+	commission := paid(trx.Commission, trx.CommissionEffect)
+	clearingFees := paid(trx.ClearingFees, trx.ClearingFeesEffect)
+	regulatoryFees := paid(trx.RegulatoryFees, trx.RegulatoryFeesEffect)
+	proprietaryIndexOptionFees := paid(trx.ProprietaryIndexOptionFees, trx.ProprietaryIndexOptionFeesEffect)
+
+	feeTotals.Commission += commission
+	feeTotals.ClearingFees += clearingFees
+	feeTotals.RegulatoryFees += regulatoryFees
+	feeTotals.ProprietaryIndexOptionFees += proprietaryIndexOptionFees
+	feeTotals.Total += commission + clearingFees + regulatoryFees + proprietaryIndexOptionFees
+}
+
+type TimelineEventType int
+
+const (
+	UndefinedTimelineEvent TimelineEventType = iota
+	OrderTimelineEvent
+	FillTimelineEvent
+	TransactionTimelineEvent
+)
+
+func (timelineEventType TimelineEventType) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + timelineEventType.String() + "\""), nil
+}
+
+func (timelineEventType TimelineEventType) String() string {
+	switch timelineEventType {
+	case OrderTimelineEvent:
+		return "order"
+	case FillTimelineEvent:
+		return "fill"
+	case TransactionTimelineEvent:
+		return "transaction"
+	default:
+		return UNK
+	}
+}
+
+// TimelineEvent is a single entry in an account's activity timeline. Exactly
+// one of Order, Fill, or Transaction is set depending on the event's Type;
+// fill events also set Order to the order that was filled.
+type TimelineEvent struct {
+	Type          TimelineEventType `json:"type"`
+	Time          time.Time         `json:"time"`
+	AccountNumber string            `json:"account-number"`
+	Symbol        string            `json:"symbol"`
+
+	Order       *OrderStatus `json:"order,omitempty"`
+	Fill        *FillStatus  `json:"fill,omitempty"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+}
+
 type Lot struct {
 	ID                string    `json:"id"`
 	TransactionID     int64     `json:"transaction-id"`
@@ -715,6 +1607,164 @@ type Order struct {
 	OrderRules *Rules `json:"rules,omitempty"`
 }
 
+// MarshalJSON encodes the order for submission. GTCDate is only sent for
+// GTD orders; other time-in-force values are rejected by the API if a
+// gtc-date is present.
+func (order *Order) MarshalJSON() ([]byte, error) {
+	type orderJSON Order
+	out := orderJSON(*order)
+	if out.TimeInForce != GTD {
+		out.GTCDate = nil
+	}
+
+	return json.Marshal(&out)
+}
+
+// NewMarketableLimitOrder creates a single-leg day order that is priced
+// like a limit order but is expected to fill immediately. The price effect is
+// derived from the action: buys are debits and sells are credits.
+func NewMarketableLimitOrder(symbol string, instrumentType InstrumentTypeChoice, action ActionType, quantity int64, price float64) *Order {
+	priceEffect := Debit
+	switch action {
+	case Sell, SellToOpen, SellToClose:
+		priceEffect = Credit
+	}
+
+	return &Order{
+		TimeInForce: Day,
+		OrderType:   MarketableLimit,
+		Price:       price,
+		PriceEffect: priceEffect,
+		Legs: []*Leg{
+			{
+				InstrumentType: instrumentType,
+				Symbol:         symbol,
+				Quantity:       quantity,
+				Action:         action,
+			},
+		},
+	}
+}
+
+// NewRollOrder creates a two-leg day limit order that closes the option
+// position in closeSymbol and opens the same number of contracts in
+// openSymbol. A positive contracts count rolls a short position (BuyToClose
+// / SellToOpen) and a negative count rolls a long position (SellToClose /
+// BuyToOpen). A positive netPrice is a credit and a negative netPrice is a
+// debit. Symbols prefixed with "./" are treated as future options.
+func NewRollOrder(closeSymbol, openSymbol string, contracts int64, netPrice float64) *Order {
+	closeAction, openAction := BuyToClose, SellToOpen
+	if contracts < 0 {
+		closeAction, openAction = SellToClose, BuyToOpen
+		contracts = -contracts
+	}
+
+	priceEffect := Credit
+	if netPrice < 0 {
+		priceEffect = Debit
+		netPrice = -netPrice
+	}
+
+	instrumentType := func(symbol string) InstrumentTypeChoice {
+		if strings.HasPrefix(symbol, "./") {
+			return FutureOption
+		}
+		return EquityOption
+	}
+
+	return &Order{
+		TimeInForce: Day,
+		OrderType:   Limit,
+		Price:       netPrice,
+		PriceEffect: priceEffect,
+		Legs: []*Leg{
+			{
+				InstrumentType: instrumentType(closeSymbol),
+				Symbol:         closeSymbol,
+				Quantity:       contracts,
+				Action:         closeAction,
+			},
+			{
+				InstrumentType: instrumentType(openSymbol),
+				Symbol:         openSymbol,
+				Quantity:       contracts,
+				Action:         openAction,
+			},
+		},
+	}
+}
+
+// NetPremium sums the per-leg prices of the order, times each leg's
+// quantity and multiplier (e.g. 100 for standard equity options, see
+// Session.Multiplier), into a net dollar amount and whether it is a credit
+// (received) or debit (paid). Sells add to the premium and buys subtract
+// from it.
+func (order *Order) NetPremium(multiplier float64) (float64, Effect) {
+	var net float64
+	for _, leg := range order.Legs {
+		amount := leg.Price * float64(leg.Quantity) * multiplier
+		switch leg.Action {
+		case Sell, SellToOpen, SellToClose:
+			net += amount
+		case Buy, BuyToOpen, BuyToClose:
+			net -= amount
+		}
+	}
+
+	switch {
+	case net > 0:
+		return net, Credit
+	case net < 0:
+		return -net, Debit
+	default:
+		return 0, UndefinedEffect
+	}
+}
+
+// Validate checks the order for common mistakes before it is submitted
+func (order *Order) Validate() error {
+	if order.OrderType == MarketableLimit && order.Price <= 0 {
+		return fmt.Errorf("%w: marketable limit orders require a price", ErrInvalidOrder)
+	}
+
+	if (order.OrderType == Stop || order.OrderType == StopLimit) && order.StopTrigger <= 0 {
+		return fmt.Errorf("%w: %s orders require a stop trigger", ErrInvalidOrder, strings.ToLower(order.OrderType.String()))
+	}
+
+	if order.OrderType == StopLimit && order.Price <= 0 {
+		return fmt.Errorf("%w: stop limit orders require a price", ErrInvalidOrder)
+	}
+
+	if order.OrderType == NotionalMarket {
+		if order.Value <= 0 {
+			return fmt.Errorf("%w: notional market orders require a positive value", ErrInvalidOrder)
+		}
+
+		if order.Price != 0 {
+			return fmt.Errorf("%w: notional market orders must not set a price; use value instead", ErrInvalidOrder)
+		}
+
+		for _, leg := range order.Legs {
+			if leg.Quantity != 0 {
+				return fmt.Errorf("%w: notional market orders must not set a quantity on leg %s", ErrInvalidOrder, leg.Symbol)
+			}
+		}
+	}
+
+	// the API expects the expiration of a GTD order in the gtc-date field
+	if order.TimeInForce == GTD && (order.GTCDate == nil || order.GTCDate.IsZero()) {
+		return fmt.Errorf("%w: GTD orders require GTCDate (sent as gtc-date, not gtd-date)", ErrInvalidOrder)
+	}
+
+	for _, leg := range order.Legs {
+		if leg.Price != 0 && (len(order.Legs) < 2 || order.OrderType != Limit) {
+			return fmt.Errorf("%w: leg %s", ErrLegPriceNotSupported, leg.Symbol)
+		}
+	}
+
+	return nil
+}
+
 type Leg struct {
 	// The type of Instrument. i.e. `Cryptocurrency`, `Equity`, `Equity Offering`, `Equity Option`, `Future` or `Future Option`
 	InstrumentType InstrumentTypeChoice `json:"instrument-type"`
@@ -723,10 +1773,13 @@ type Leg struct {
 	Symbol string `json:"symbol"`
 
 	// The size of the contract. Required for all orders but notional market.
-	Quantity int64 `json:"quantity"`
+	Quantity int64 `json:"quantity,omitempty"`
 
 	// The directional action of the leg. i.e. Sell to Open, Sell to Close, Buy to Open, Buy to Close, Sell or Buy. Note: Buy and Sell are only applicable to Futures orders.
 	Action ActionType `json:"action"`
+
+	// Optional per-leg limit price. Only valid on multi-leg limit orders.
+	Price float64 `json:"price,omitempty"`
 }
 
 type LegStatus struct {
@@ -741,12 +1794,33 @@ type LegStatus struct {
 
 	RemainingQuantity string `json:"remaining-quantity"`
 
+	// The direction of the position the leg opens or closes, i.e. `Long` or `Short`. Not returned for every order.
+	QuantityDirection string `json:"quantity-direction,omitempty"`
+
 	// The directional action of the leg. i.e. Sell to Open, Sell to Close, Buy to Open, Buy to Close, Sell or Buy. Note: Buy and Sell are only applicable to Futures orders.
 	Action ActionType `json:"action"`
 
+	// Per-leg limit price, if one was set on a multi-leg limit order
+	Price float64 `json:"price,omitempty"`
+
 	Fills []*FillStatus `json:"fills"`
 }
 
+// FilledQuantity returns the quantity of the leg that has been filled
+func (legStatus *LegStatus) FilledQuantity() float64 {
+	return parseQuantity(legStatus.Quantity) - parseQuantity(legStatus.RemainingQuantity)
+}
+
+// parseQuantity converts a quantity string returned by the API to a float,
+// returning 0 if it cannot be parsed
+func parseQuantity(quantity string) float64 {
+	parsed, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		return 0
+	}
+	return parsed
+}
+
 type FillStatus struct {
 	ExternalGroupFillID string    `json:"ext-group-fill-id"`
 	ExternalExecutionID string    `json:"ext-exec-id"`
@@ -799,6 +1873,9 @@ type Condition struct {
 
 	// The price at which the condition triggers
 	Threshold float64 `json:"threshold"`
+
+	// Interpret the threshold as a notional value rather than a price
+	IsThresholdBasedOnNotional bool `json:"is-threshold-based-on-notional,omitempty"`
 }
 
 type ConditionStatus struct {
@@ -848,6 +1925,24 @@ type OrderResponse struct {
 	Warnings            []*ErrorMsg        `json:"warnings"`
 }
 
+// OrderID returns the id assigned to the submitted order or an empty string
+// if the order was not accepted. An order can be accepted with warnings.
+func (orderResponse *OrderResponse) OrderID() string {
+	if orderResponse == nil || orderResponse.Order == nil {
+		return ""
+	}
+	return orderResponse.Order.ID
+}
+
+// IsRejected returns true and the reject reason if the order was accepted by
+// the API but was immediately rejected, e.g. by the exchange
+func (orderResponse *OrderResponse) IsRejected() (bool, string) {
+	if orderResponse == nil || orderResponse.Order == nil || orderResponse.Order.Status != "Rejected" {
+		return false, ""
+	}
+	return true, orderResponse.Order.RejectReason
+}
+
 type BuyingPowerChange struct {
 	ChangeInMarginRequirement            float64 `json:"change-in-margin-requirement"`
 	ChangeInMarginRequirementEffect      Effect  `json:"change-in-margin-requirement-effect"`
@@ -864,6 +1959,17 @@ type BuyingPowerChange struct {
 	EffectOnCash                         Effect  `json:"effect"`
 }
 
+// MarginRequirement is the combined effect of a group of orders on an
+// account's margin requirement and buying power
+type MarginRequirement struct {
+	ChangeInMarginRequirement float64          `json:"change-in-margin-requirement"` // positive if the orders increase the margin requirement
+	ChangeInBuyingPower       float64          `json:"change-in-buying-power"`       // negative if the orders reduce buying power
+	CurrentBuyingPower        float64          `json:"current-buying-power"`         // buying power before the orders
+	NewBuyingPower            float64          `json:"new-buying-power"`             // buying power after the orders
+	TotalFees                 float64          `json:"total-fees"`                   // fees paid for all orders
+	Orders                    []*OrderResponse `json:"orders"`                       // dry-run response of each order
+}
+
 type FeeInfo struct {
 	RegulatoryFees                   float64 `json:"regulatory-fees"`
 	RegulatoryFeesEffect             Effect  `json:"regulatory-fees-effect"`
@@ -882,7 +1988,7 @@ type OrderStatus struct {
 	TimeInForce              TimeInForceChoice    `json:"time-in-force"`
 	TerminalAt               time.Time            `json:"terminal-at"`
 	Editable                 bool                 `json:"editable"`
-	ContingentStatus         string               `json:"contingent-status"`
+	ContingentStatus         ContingentStatus     `json:"contingent-status"`
 	Legs                     []*LegStatus         `json:"legs"`
 	GTCDate                  time.Time            `json:"gtc-date"`
 	UpdatedAt                string               `json:"updated-at"`
@@ -893,7 +1999,7 @@ type OrderStatus struct {
 	Price                    float64              `json:"price"`
 	CancelUsername           string               `json:"cancel-username"`
 	AccountNumber            string               `json:"account-number"`
-	ConfirmationStatus       string               `json:"confirmation-status"`
+	ConfirmationStatus       ConfirmationStatus   `json:"confirmation-status"`
 	CancelUserID             string               `json:"cancel-user-id"`
 	Cancellable              bool                 `json:"cancellable"`
 	ValueEffect              Effect               `json:"value-effect"`
@@ -917,6 +2023,128 @@ type OrderStatus struct {
 	ReceivedAt               time.Time            `json:"received-at"`
 }
 
+// IsTerminal returns true if the order can no longer change state
+func (orderStatus *OrderStatus) IsTerminal() bool {
+	switch orderStatus.Status {
+	case "Filled", "Cancelled", "Expired", "Rejected", "Removed", "Partially Removed":
+		return true
+	default:
+		return false
+	}
+}
+
+// IsContingentPending returns true if the order is a contingent order whose
+// conditions have not yet been met
+func (orderStatus *OrderStatus) IsContingentPending() bool {
+	return orderStatus.ContingentStatus == PendingCondition && !orderStatus.IsTerminal()
+}
+
+// IsPartiallyFilled returns true if some, but not all, of the order has been filled
+func (orderStatus *OrderStatus) IsPartiallyFilled() bool {
+	var filled, remaining float64
+	for _, leg := range orderStatus.Legs {
+		filled += leg.FilledQuantity()
+		remaining += parseQuantity(leg.RemainingQuantity)
+	}
+	return filled > 0 && remaining > 0
+}
+
+// ToOrder reconstructs a submittable Order from the order status. This is
+// useful for correcting and resubmitting a rejected order. An Order carries a
+// single set of rules, so an error wrapping ErrInvalidOrder is returned if
+// the status has more than one order rule.
+func (orderStatus *OrderStatus) ToOrder() (*Order, error) {
+	order := &Order{
+		TimeInForce: orderStatus.TimeInForce,
+		OrderType:   orderStatus.OrderType,
+		Price:       orderStatus.Price,
+		PriceEffect: orderStatus.PriceEffect,
+		Value:       orderStatus.Value,
+		ValueEffect: orderStatus.ValueEffect,
+		Legs:        make([]*Leg, len(orderStatus.Legs)),
+	}
+
+	if !orderStatus.GTCDate.IsZero() {
+		gtcDate := orderStatus.GTCDate
+		order.GTCDate = &gtcDate
+	}
+
+	if orderStatus.StopTrigger != "" {
+		stopTrigger, err := strconv.ParseFloat(orderStatus.StopTrigger, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stop-trigger %q: %w", orderStatus.StopTrigger, err)
+		}
+		order.StopTrigger = stopTrigger
+	}
+
+	for idx, leg := range orderStatus.Legs {
+		quantity, err := strconv.ParseFloat(leg.Quantity, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity %q for leg %s: %w", leg.Quantity, leg.Symbol, err)
+		}
+
+		if quantity != math.Trunc(quantity) {
+			return nil, fmt.Errorf("%w: fractional quantity %q for leg %s cannot be resubmitted", ErrInvalidOrder, leg.Quantity, leg.Symbol)
+		}
+
+		order.Legs[idx] = &Leg{
+			InstrumentType: leg.InstrumentType,
+			Symbol:         leg.Symbol,
+			Quantity:       int64(quantity),
+			Action:         leg.Action,
+			Price:          leg.Price,
+		}
+	}
+
+	if len(orderStatus.OrderRule) > 1 {
+		return nil, fmt.Errorf("%w: %d order rules cannot be resubmitted as one", ErrInvalidOrder, len(orderStatus.OrderRule))
+	}
+
+	if len(orderStatus.OrderRule) == 1 {
+		rule := orderStatus.OrderRule[0]
+		order.OrderRules = &Rules{
+			RouteAfter: rule.RouteAfter,
+			CancelAt:   rule.CancelAt,
+			Conditions: make([]*Condition, len(rule.Conditions)),
+		}
+
+		for idx, condition := range rule.Conditions {
+			order.OrderRules.Conditions[idx] = &Condition{
+				Action:         condition.Action,
+				Symbol:         condition.Symbol,
+				InstrumentType: condition.InstrumentType,
+				Indicator:      condition.Indicator,
+				Comparator:     condition.Comparator,
+				Threshold:      condition.Threshold,
+
+				IsThresholdBasedOnNotional: condition.IsThresholdBasedOnNotional,
+			}
+		}
+	}
+
+	return order, nil
+}
+
+// RejectCode classifies the free-form RejectReason returned by tastytrade.
+// The classification is best-effort; the original text is always available
+// in RejectReason.
+func (orderStatus *OrderStatus) RejectCode() RejectCode {
+	return RejectCodeFromString(orderStatus.RejectReason)
+}
+
+// GroupByComplexOrder groups orders by their ComplexOrderID. Orders that
+// are not part of a complex order (e.g. OCO, OTOCO) are omitted.
+func GroupByComplexOrder(orders []*OrderStatus) map[string][]*OrderStatus {
+	groups := make(map[string][]*OrderStatus)
+	for _, order := range orders {
+		if order.ComplexOrderID == "" {
+			continue
+		}
+		groups[order.ComplexOrderID] = append(groups[order.ComplexOrderID], order)
+	}
+	return groups
+}
+
 type ErrorMsg struct {
 	Code        string `json:"code"`
 	Message     string `json:"message"`
@@ -0,0 +1,601 @@
+// Copyright 2024
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gotasty
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/tidwall/gjson"
+)
+
+func TestToOrderRejectsFractionalQuantity(t *testing.T) {
+	status := &OrderStatus{
+		TimeInForce: Day,
+		OrderType:   Market,
+		Legs: []*LegStatus{
+			{InstrumentType: Equity, Symbol: "AAPL", Quantity: "0.5", Action: Buy},
+		},
+	}
+
+	if _, err := status.ToOrder(); !errors.Is(err, ErrInvalidOrder) {
+		t.Errorf("expected ErrInvalidOrder, got %v", err)
+	}
+}
+
+func TestTransactionFilterOptsValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		filter TransactionFilterOpts
+		want   error
+	}{
+		{"empty", TransactionFilterOpts{}, nil},
+		{"known", TransactionFilterOpts{
+			TransactionTypes:    []string{TransactionTypeTrade, TransactionTypeMoneyMovement},
+			TransactionSubTypes: []string{"Assignment"},
+		}, nil},
+		{"unknown type", TransactionFilterOpts{TransactionTypes: []string{TransactionTypeTrade, "Trades"}}, ErrUnknownTransactionType},
+		{"unknown sub-type", TransactionFilterOpts{TransactionSubTypes: []string{"assignment"}}, ErrUnknownTransactionSubType},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.filter.Validate()
+			if !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestOrderValidateNotionalMarket(t *testing.T) {
+	testCases := []struct {
+		name  string
+		order Order
+		want  error
+	}{
+		{"valid", Order{OrderType: NotionalMarket, TimeInForce: Day, Value: 100, ValueEffect: Debit, Legs: []*Leg{
+			{InstrumentType: Equity, Symbol: "AAPL", Action: BuyToOpen},
+		}}, nil},
+		{"zero value", Order{OrderType: NotionalMarket, TimeInForce: Day, Legs: []*Leg{
+			{InstrumentType: Equity, Symbol: "AAPL", Action: BuyToOpen},
+		}}, ErrInvalidOrder},
+		{"negative value", Order{OrderType: NotionalMarket, TimeInForce: Day, Value: -100, Legs: []*Leg{
+			{InstrumentType: Equity, Symbol: "AAPL", Action: BuyToOpen},
+		}}, ErrInvalidOrder},
+		{"price set", Order{OrderType: NotionalMarket, TimeInForce: Day, Value: 100, Price: 185.50, Legs: []*Leg{
+			{InstrumentType: Equity, Symbol: "AAPL", Action: BuyToOpen},
+		}}, ErrInvalidOrder},
+		{"leg quantity set", Order{OrderType: NotionalMarket, TimeInForce: Day, Value: 100, Legs: []*Leg{
+			{InstrumentType: Equity, Symbol: "AAPL", Quantity: 1, Action: BuyToOpen},
+		}}, ErrInvalidOrder},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.order.Validate()
+			if !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestMarketDataParamKey(t *testing.T) {
+	testCases := []struct {
+		instrumentType InstrumentTypeChoice
+		want           string
+	}{
+		{UndefinedInstrument, ""},
+		{Cryptocurrency, "cryptocurrency[]"},
+		{Equity, "equity[]"},
+		{EquityOffering, ""},
+		{EquityOption, "equity-option[]"},
+		{Future, "future[]"},
+		{FutureOption, "future-option[]"},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.instrumentType.MarketDataParamKey(); got != tc.want {
+			t.Errorf("%s.MarketDataParamKey() = %q, want %q", tc.instrumentType, got, tc.want)
+		}
+	}
+}
+
+func TestOrderNetPremium(t *testing.T) {
+	testCases := []struct {
+		name       string
+		legs       []*Leg
+		wantAmount float64
+		wantEffect Effect
+	}{
+		{
+			name: "credit spread",
+			legs: []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00450000", Quantity: 2, Action: SellToOpen, Price: 3.10},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00445000", Quantity: 2, Action: BuyToOpen, Price: 1.85},
+			},
+			wantAmount: 250,
+			wantEffect: Credit,
+		},
+		{
+			name: "debit butterfly",
+			legs: []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00445000", Quantity: 1, Action: BuyToOpen, Price: 7.50},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00450000", Quantity: 2, Action: SellToOpen, Price: 4.60},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00455000", Quantity: 1, Action: BuyToOpen, Price: 2.50},
+			},
+			wantAmount: 80,
+			wantEffect: Debit,
+		},
+		{
+			name: "no net premium",
+			legs: []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00445000", Quantity: 1, Action: BuyToOpen, Price: 2},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119C00450000", Quantity: 1, Action: SellToOpen, Price: 2},
+			},
+			wantEffect: UndefinedEffect,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			amount, effect := (&Order{Legs: tc.legs}).NetPremium(100)
+			if math.Abs(amount-tc.wantAmount) > 1e-9 || effect != tc.wantEffect {
+				t.Errorf("expected %v %s, got %v %s", tc.wantAmount, tc.wantEffect, amount, effect)
+			}
+		})
+	}
+}
+
+func TestBalanceBuyingPowerPercentUsed(t *testing.T) {
+	testCases := []struct {
+		name    string
+		balance Balance
+		want    float64
+	}{
+		{"margin account", Balance{NetLiquidatingValue: 50000, EquityBuyingPower: 70000, DerivativeBuyingPower: 35000}, 30},
+		{"cash account", Balance{NetLiquidatingValue: 20000, EquityBuyingPower: 5000, DerivativeBuyingPower: 5000}, 75},
+		{"unused", Balance{NetLiquidatingValue: 10000, EquityBuyingPower: 20000, DerivativeBuyingPower: 10000}, 0},
+		{"over-used", Balance{NetLiquidatingValue: 10000, DerivativeBuyingPower: -500}, 100},
+		{"no net-liq", Balance{DerivativeBuyingPower: 100}, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.balance.BuyingPowerPercentUsed(); math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFilterOpenOnly(t *testing.T) {
+	positions := []*Position{
+		{Symbol: "AAPL", Quantity: 10, QuantityDirection: "Long"},
+		{Symbol: "MSFT", Quantity: 0, QuantityDirection: "Zero"},
+		{Symbol: "SPY", Quantity: 5, QuantityDirection: "Short"},
+	}
+
+	open := FilterOpenOnly(positions)
+	if len(open) != 2 || open[0].Symbol != "AAPL" || open[1].Symbol != "SPY" {
+		t.Errorf("expected AAPL and SPY, got %+v", open)
+	}
+}
+
+func TestTransactionLots(t *testing.T) {
+	lots := []*Lot{
+		{ID: "1", Quantity: 30, Price: 180},
+		{ID: "2", Quantity: 50, Price: 182.5},
+		{ID: "3", Quantity: 20, Price: 185},
+	}
+
+	testCases := []struct {
+		name        string
+		transaction Transaction
+		wantTotal   float64
+		want        error
+	}{
+		{"reconciled", Transaction{ID: 1, Quantity: 100, Lots: lots}, 100, nil},
+		{"mismatch", Transaction{ID: 2, Quantity: 90, Lots: lots}, 100, ErrLotQuantityMismatch},
+		{"no lots", Transaction{ID: 3, Quantity: 100}, 0, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if total := tc.transaction.TotalLotQuantity(); total != tc.wantTotal {
+				t.Errorf("expected total %v, got %v", tc.wantTotal, total)
+			}
+
+			if err := tc.transaction.ReconcileLots(); !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestAuthorityLevel(t *testing.T) {
+	testCases := []struct {
+		input    string
+		want     AuthorityLevel
+		canTrade bool
+	}{
+		{"owner", Owner, true},
+		{"full-trading", FullTrading, true},
+		{"read-only", ReadOnly, false},
+		{"trading-only", UndefinedAuthorityLevel, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			level := AuthorityLevelFromString(tc.input)
+			if level != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, level)
+			}
+
+			if level != UndefinedAuthorityLevel {
+				data, err := level.MarshalJSON()
+				if err != nil || string(data) != `"`+tc.input+`"` {
+					t.Errorf("expected %q, got %s (%v)", tc.input, data, err)
+				}
+			}
+
+			account := &Account{AuthorityLevel: level}
+			if account.CanTrade() != tc.canTrade {
+				t.Errorf("expected CanTrade %v, got %v", tc.canTrade, account.CanTrade())
+			}
+		})
+	}
+}
+
+func TestRejectCode(t *testing.T) {
+	testCases := []struct {
+		reason string
+		want   RejectCode
+	}{
+		{"", UndefinedRejectCode},
+		{"Insufficient buying power to place this order", InsufficientBuyingPower},
+		{"Not enough shares to sell", InsufficientPosition},
+		{"The market is closed", MarketClosed},
+		{"Invalid symbol: XYZZY", InvalidSymbol},
+		{"Price does not meet the minimum tick size", InvalidPrice},
+		{"Duplicate order", DuplicateOrder},
+		{"Account is restricted", OtherReject},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want.String(), func(t *testing.T) {
+			status := &OrderStatus{RejectReason: tc.reason}
+			if got := status.RejectCode(); got != tc.want {
+				t.Errorf("expected %v for %q, got %v", tc.want, tc.reason, got)
+			}
+		})
+	}
+}
+
+func TestOrderLegPrices(t *testing.T) {
+	spread := func(orderType OrderTypeChoice) *Order {
+		return &Order{
+			TimeInForce: Day,
+			OrderType:   orderType,
+			Price:       1.25,
+			PriceEffect: Credit,
+			Legs: []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00450000", Quantity: 1, Action: SellToOpen, Price: 3.10},
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00445000", Quantity: 1, Action: BuyToOpen, Price: 1.85},
+			},
+		}
+	}
+
+	order := spread(Limit)
+	if err := order.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	data, err := order.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+
+	for _, want := range []string{`"action":"Sell to Open","price":3.1`, `"action":"Buy to Open","price":1.85`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected order to contain %s, got %s", want, data)
+		}
+	}
+
+	if err := spread(Market).Validate(); !errors.Is(err, ErrLegPriceNotSupported) {
+		t.Errorf("expected ErrLegPriceNotSupported for a market order, got %v", err)
+	}
+
+	single := spread(Limit)
+	single.Legs = single.Legs[:1]
+	if err := single.Validate(); !errors.Is(err, ErrLegPriceNotSupported) {
+		t.Errorf("expected ErrLegPriceNotSupported for a single leg, got %v", err)
+	}
+}
+
+func TestConfirmationStatus(t *testing.T) {
+	testCases := []struct {
+		input      string
+		want       ConfirmationStatus
+		wantString string
+	}{
+		{"Confirmed", Confirmed, "Confirmed"},
+		{"confirmed", Confirmed, "Confirmed"},
+		{"Pending", PendingConfirmation, "Pending"},
+		{"pending-confirmation", PendingConfirmation, "Pending"},
+		{"Pending Confirmation", PendingConfirmation, "Pending"},
+		{"", UndefinedConfirmationStatus, UNK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			status := ConfirmationStatusFromString(tc.input)
+			if status != tc.want || status.String() != tc.wantString {
+				t.Errorf("expected %v (%s), got %v (%s)", tc.want, tc.wantString, status, status)
+			}
+
+			order := parseOrderStatus(gjson.Parse(`{"id":"1","confirmation-status":"` + tc.input + `"}`))
+			if order.ConfirmationStatus != tc.want {
+				t.Errorf("expected parsed status %v, got %v", tc.want, order.ConfirmationStatus)
+			}
+		})
+	}
+}
+
+func TestPartialFills(t *testing.T) {
+	testCases := []struct {
+		name          string
+		quantity      string
+		remaining     string
+		wantFilled    float64
+		wantIsPartial bool
+	}{
+		{"unfilled", "10", "10", 0, false},
+		{"partial", "10", "4", 6, true},
+		{"fully filled", "10", "0", 10, false},
+		{"fractional partial", "1.5", "0.5", 1, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			leg := &LegStatus{Symbol: "AAPL", Quantity: tc.quantity, RemainingQuantity: tc.remaining}
+			if filled := leg.FilledQuantity(); filled != tc.wantFilled {
+				t.Errorf("expected filled quantity %v, got %v", tc.wantFilled, filled)
+			}
+
+			order := &OrderStatus{Legs: []*LegStatus{leg}}
+			if order.IsPartiallyFilled() != tc.wantIsPartial {
+				t.Errorf("expected IsPartiallyFilled %v, got %v", tc.wantIsPartial, order.IsPartiallyFilled())
+			}
+		})
+	}
+}
+
+func TestPositionsSnapshot(t *testing.T) {
+	positions := []*Position{
+		{Symbol: "AAPL", UnderlyingSymbol: "AAPL", Quantity: 100, QuantityDirection: "Long", AverageOpenPrice: 150, ClosePrice: 180},
+		{Symbol: "AAPL  240119C00190000", UnderlyingSymbol: "AAPL", Quantity: 50, QuantityDirection: "Short", AverageOpenPrice: 180, ClosePrice: 180},
+		{Symbol: "SPY", UnderlyingSymbol: "SPY", Quantity: 10, QuantityDirection: "Long", AverageOpenPrice: 400, ClosePrice: 470},
+	}
+
+	snapshot := PositionsSnapshot(positions)
+
+	want := &PortfolioSnapshot{Underlyings: map[string]*UnderlyingSnapshot{
+		"AAPL": {UnderlyingSymbol: "AAPL", Quantity: 50, AveragePrice: 160, Mark: 180},
+		"SPY":  {UnderlyingSymbol: "SPY", Quantity: 10, AveragePrice: 400, Mark: 470},
+	}}
+	if !reflect.DeepEqual(snapshot, want) {
+		t.Errorf("unexpected snapshot:\n got: %+v %+v\nwant: %+v %+v", snapshot.Underlyings["AAPL"], snapshot.Underlyings["SPY"],
+			want.Underlyings["AAPL"], want.Underlyings["SPY"])
+	}
+
+	reordered := []*Position{positions[2], positions[1], positions[0]}
+	if !reflect.DeepEqual(PositionsSnapshot(reordered), snapshot) {
+		t.Error("expected the snapshot to be independent of position order")
+	}
+
+	changed := *positions[2]
+	changed.Quantity = 11
+	if reflect.DeepEqual(PositionsSnapshot([]*Position{positions[0], positions[1], &changed}), snapshot) {
+		t.Error("expected a quantity change to change the snapshot")
+	}
+}
+
+func TestNewRollOrder(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contracts   int64
+		netPrice    float64
+		quantity    int64
+		closeAction ActionType
+		openAction  ActionType
+		wantEffect  Effect
+	}{
+		{"short put for a credit", 2, 0.45, 2, BuyToClose, SellToOpen, Credit},
+		{"long put for a debit", -3, -1.2, 3, SellToClose, BuyToOpen, Debit},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			order := NewRollOrder("SPY   240119P00450000", "SPY   240126P00445000", tc.contracts, tc.netPrice)
+			if err := order.Validate(); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+
+			if order.OrderType != Limit || order.Price != math.Abs(tc.netPrice) || order.PriceEffect != tc.wantEffect {
+				t.Errorf("expected a %s limit order at %v, got %s %s at %v", tc.wantEffect, math.Abs(tc.netPrice), order.PriceEffect, order.OrderType, order.Price)
+			}
+
+			want := []*Leg{
+				{InstrumentType: EquityOption, Symbol: "SPY   240119P00450000", Quantity: tc.quantity, Action: tc.closeAction},
+				{InstrumentType: EquityOption, Symbol: "SPY   240126P00445000", Quantity: tc.quantity, Action: tc.openAction},
+			}
+			if !reflect.DeepEqual(order.Legs, want) {
+				t.Errorf("unexpected legs: %+v %+v", order.Legs[0], order.Legs[1])
+			}
+		})
+	}
+
+	if order := NewRollOrder("./ESH4 EW3F4 240119P4700", "./ESH4 EW4F4 240126P4700", 1, 5); order.Legs[0].InstrumentType != FutureOption {
+		t.Errorf("expected future option legs, got %s", order.Legs[0].InstrumentType)
+	}
+}
+
+func TestOrderValidateStopOrders(t *testing.T) {
+	legs := []*Leg{{InstrumentType: Equity, Symbol: "AAPL", Quantity: 10, Action: SellToClose}}
+
+	testCases := []struct {
+		name  string
+		order Order
+		want  error
+	}{
+		{"stop", Order{OrderType: Stop, TimeInForce: Day, StopTrigger: 170, Legs: legs}, nil},
+		{"stop without trigger", Order{OrderType: Stop, TimeInForce: Day, Legs: legs}, ErrInvalidOrder},
+		{"stop limit", Order{OrderType: StopLimit, TimeInForce: Day, StopTrigger: 170, Price: 169.5, PriceEffect: Credit, Legs: legs}, nil},
+		{"stop limit without trigger", Order{OrderType: StopLimit, TimeInForce: Day, Price: 169.5, PriceEffect: Credit, Legs: legs}, ErrInvalidOrder},
+		{"stop limit without price", Order{OrderType: StopLimit, TimeInForce: Day, StopTrigger: 170, Legs: legs}, ErrInvalidOrder},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.order.Validate()
+			if !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestBalanceDiff(t *testing.T) {
+	prev := &Balance{
+		AccountNumber:           "5WT0001",
+		NetLiquidatingValue:     50000,
+		CashBalance:             20000,
+		EquityBuyingPower:       60000,
+		DerivativeBuyingPower:   30000,
+		DayTradingBuyingPower:   0,
+		CashAvailableToWithdraw: 20000,
+		MaintenanceRequirement:  10000,
+	}
+	cur := &Balance{
+		AccountNumber:           "5WT0001",
+		NetLiquidatingValue:     51250.5,
+		CashBalance:             15000,
+		EquityBuyingPower:       55000,
+		DerivativeBuyingPower:   27500,
+		DayTradingBuyingPower:   100000,
+		CashAvailableToWithdraw: 15000,
+		MaintenanceRequirement:  12500,
+	}
+
+	want := &BalanceDelta{
+		AccountNumber:           "5WT0001",
+		NetLiquidatingValue:     1250.5,
+		CashBalance:             -5000,
+		EquityBuyingPower:       -5000,
+		DerivativeBuyingPower:   -2500,
+		DayTradingBuyingPower:   100000,
+		CashAvailableToWithdraw: -5000,
+		MaintenanceRequirement:  2500,
+	}
+	if got := BalanceDiff(prev, cur); *got != *want {
+		t.Errorf("unexpected delta:\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestEffectFromStringStrict(t *testing.T) {
+	testCases := []struct {
+		input   string
+		want    Effect
+		wantErr error
+	}{
+		{"", UndefinedEffect, nil},
+		{"None", UndefinedEffect, nil},
+		{"Credit", Credit, nil},
+		{"Debit", Debit, nil},
+		{"credit-ish", UndefinedEffect, ErrUnknownEffect},
+		{"\x00garbage", UndefinedEffect, ErrUnknownEffect},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			effect, err := EffectFromStringStrict(tc.input)
+			if effect != tc.want || !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v (%v), got %v (%v)", tc.want, tc.wantErr, effect, err)
+			}
+		})
+	}
+
+	// unknown effects in API responses are parsed as undefined rather than failing
+	order := parseOrderStatus(gjson.Parse(`{"id":"1","price-effect":"Sideways","value-effect":"Credit"}`))
+	if order.PriceEffect != UndefinedEffect || order.ValueEffect != Credit {
+		t.Errorf("expected undefined price effect and credit value effect, got %s and %s", order.PriceEffect, order.ValueEffect)
+	}
+}
+
+func TestOrderValidateGTD(t *testing.T) {
+	legs := []*Leg{{InstrumentType: Equity, Symbol: "AAPL", Quantity: 10, Action: BuyToOpen}}
+	gtcDate := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+
+	order := Order{OrderType: Limit, TimeInForce: GTD, Price: 180, PriceEffect: Debit, Legs: legs}
+	err := order.Validate()
+	if !errors.Is(err, ErrInvalidOrder) {
+		t.Fatalf("expected ErrInvalidOrder for a GTD order without GTCDate, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "gtc-date") {
+		t.Errorf("expected the error to mention gtc-date, got %q", err)
+	}
+
+	order.GTCDate = &gtcDate
+	if err := order.Validate(); err != nil {
+		t.Errorf("expected a GTD order with GTCDate to validate, got %v", err)
+	}
+}
+
+func TestOrderMarshalGTCDate(t *testing.T) {
+	gtcDate := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	legs := []*Leg{{InstrumentType: Equity, Symbol: "AAPL", Quantity: 10, Action: BuyToOpen}}
+
+	testCases := []struct {
+		name        string
+		order       *Order
+		wantGTCDate bool
+	}{
+		{"gtc", &Order{OrderType: Limit, TimeInForce: GTC, Price: 180, PriceEffect: Debit, Legs: legs}, false},
+		{"gtc with date", &Order{OrderType: Limit, TimeInForce: GTC, GTCDate: &gtcDate, Price: 180, PriceEffect: Debit, Legs: legs}, false},
+		{"gtd", &Order{OrderType: Limit, TimeInForce: GTD, GTCDate: &gtcDate, Price: 180, PriceEffect: Debit, Legs: legs}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.order)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+
+			if got := gjson.GetBytes(data, "gtc-date").Exists(); got != tc.wantGTCDate {
+				t.Errorf("expected gtc-date present %v, got %v in %s", tc.wantGTCDate, got, data)
+			}
+		})
+	}
+}